@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tanema/mal/src/core"
+)
+
+const buildUsage = `Usage: wot build [options] file
+
+Writes an executable that runs the file. The files that it loads with load-file,
+require and ns are found and bundled with it so no .mal files are needed to run
+it. Only literal paths and quoted namespace names can be found.
+
+Options:
+  -o <file>   the executable to write, defaults to the file name without .mal
+  -I <dir>    add a directory to *load-path*, can be repeated
+`
+
+func runBuild(args []string) int {
+	var output string
+	var loadPaths stringList
+	flags := flag.NewFlagSet("wot build", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	flags.StringVar(&output, "o", "", "")
+	flags.Var(&loadPaths, "I", "")
+	files, err := parseInterspersed(flags, args)
+	if err == flag.ErrHelp {
+		fmt.Print(buildUsage)
+		return exitOK
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "wot: %v\n\n%v", err, buildUsage)
+		return exitUsage
+	} else if len(files) != 1 {
+		fmt.Fprintf(os.Stderr, "wot: build expects one file\n\n%v", buildUsage)
+		return exitUsage
+	}
+	main := files[0]
+	if output == "" {
+		output = strings.TrimSuffix(filepath.Base(main), filepath.Ext(main))
+	}
+
+	defaultEnv := core.DefaultNamespace()
+	setup(defaultEnv, loadPaths, nil)
+	deps, err := core.Dependencies(defaultEnv, main)
+	if err != nil {
+		return uncaught(err)
+	}
+	b, err := newBundle(deps)
+	if err != nil {
+		return uncaught(err)
+	}
+	if err := b.writeExecutable(output); err != nil {
+		return uncaught(err)
+	}
+	return exitOK
+}
+
+// parseInterspersed parses flags that can come before or after the other
+// arguments, which are returned
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	rest := []string{}
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		} else if flags.NArg() == 0 {
+			return rest, nil
+		}
+		rest = append(rest, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
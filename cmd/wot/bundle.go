@@ -0,0 +1,258 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tanema/mal/src/core"
+)
+
+// A built program is a copy of the wot executable with a zip archive of the
+// program's source files appended to it. The archive is followed by a trailer of
+// its size as a little endian uint64 and bundleMagic so that the executable can
+// find it when it starts.
+const (
+	bundleMagic  = "wotbndl1"
+	trailerSize  = 8 + len(bundleMagic)
+	manifestName = "wot-bundle.json"
+)
+
+// manifest describes how to run the program in a bundle
+type manifest struct {
+	Main     string   `json:"main"`
+	LoadPath []string `json:"load_path,omitempty"`
+}
+
+// bundle is a program and the files that it loads
+type bundle struct {
+	manifest
+	files map[string][]byte
+}
+
+// newBundle collects the dependencies into a bundle. Files keep their positions
+// relative to each other so that relative loads and namespace lookups still work
+// at run time. The first dependency is the main file.
+func newBundle(deps []core.Dependency) (*bundle, error) {
+	dirs := []string{}
+	for _, dep := range deps {
+		dirs = append(dirs, dep.Dir, filepath.Dir(dep.Path))
+	}
+	root := commonDir(dirs)
+	b := &bundle{files: map[string][]byte{}}
+	for i, dep := range deps {
+		rel, err := filepath.Rel(root, dep.Path)
+		if err != nil {
+			return nil, err
+		}
+		source, err := ioutil.ReadFile(dep.Path)
+		if err != nil {
+			return nil, err
+		}
+		name := filepath.ToSlash(rel)
+		b.files[name] = source
+		if i == 0 {
+			b.Main = name
+		}
+		if dep.Search {
+			dir, err := filepath.Rel(root, dep.Dir)
+			if err != nil {
+				return nil, err
+			}
+			b.addLoadPath(filepath.ToSlash(dir))
+		}
+	}
+	return b, nil
+}
+
+func (b *bundle) addLoadPath(dir string) {
+	for _, existing := range b.LoadPath {
+		if existing == dir {
+			return
+		}
+	}
+	b.LoadPath = append(b.LoadPath, dir)
+}
+
+// commonDir finds the deepest directory that contains all of the directories
+func commonDir(dirs []string) string {
+	common := dirs[0]
+	for _, dir := range dirs[1:] {
+		for common != dir && !strings.HasPrefix(dir, common+string(filepath.Separator)) {
+			parent := filepath.Dir(common)
+			if parent == common {
+				break
+			}
+			common = parent
+		}
+	}
+	return common
+}
+
+// archive writes the bundle as a zip archive. Files are written in order with
+// fixed timestamps so that building the same program twice gives the same output.
+func (b *bundle) archive() ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	data, err := json.Marshal(b.manifest)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for name := range b.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if err := writeZipFile(zw, manifestName, data); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if err := writeZipFile(zw, name, b.files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeZipFile(zw *zip.Writer, name string, data []byte) error {
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// writeExecutable writes a copy of the running executable, without any bundle
+// it already has, with the bundle appended
+func (b *bundle) writeExecutable(out string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	exeData, err := ioutil.ReadFile(exe)
+	if err != nil {
+		return err
+	}
+	archive, err := b.archive()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	buf.Write(stripBundle(exeData))
+	buf.Write(archive)
+	buf.Write(trailer(len(archive)))
+	return ioutil.WriteFile(out, buf.Bytes(), 0755)
+}
+
+func trailer(size int) []byte {
+	data := make([]byte, trailerSize)
+	binary.LittleEndian.PutUint64(data, uint64(size))
+	copy(data[8:], bundleMagic)
+	return data
+}
+
+// stripBundle removes a bundle from the end of an executable if it has one
+func stripBundle(exe []byte) []byte {
+	if len(exe) < trailerSize || string(exe[len(exe)-len(bundleMagic):]) != bundleMagic {
+		return exe
+	}
+	size := binary.LittleEndian.Uint64(exe[len(exe)-trailerSize:])
+	if size > uint64(len(exe)-trailerSize) {
+		return exe
+	}
+	return exe[:len(exe)-trailerSize-int(size)]
+}
+
+// app is a program bundled into the running executable
+type app struct {
+	manifest
+	files *zip.Reader
+}
+
+// openApp reads the bundle appended to the running executable. It returns nil
+// if there is not one. The executable is left open while the bundled program
+// runs so that its files can be read.
+func openApp() (*app, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, nil
+	}
+	file, err := os.Open(exe)
+	if err != nil {
+		return nil, nil
+	}
+	a, err := readApp(file)
+	if a == nil {
+		file.Close()
+	}
+	return a, err
+}
+
+// readApp reads the bundle at the end of the file, or returns nil if there is
+// not one
+func readApp(file *os.File) (*app, error) {
+	info, err := file.Stat()
+	if err != nil || info.Size() < int64(trailerSize) {
+		return nil, nil
+	}
+	end := make([]byte, trailerSize)
+	if _, err := file.ReadAt(end, info.Size()-int64(trailerSize)); err != nil {
+		return nil, err
+	} else if string(end[8:]) != bundleMagic {
+		return nil, nil
+	}
+	size := int64(binary.LittleEndian.Uint64(end))
+	start := info.Size() - int64(trailerSize) - size
+	if size <= 0 || start < 0 {
+		return nil, errors.New("corrupt program bundle")
+	}
+	files, err := zip.NewReader(io.NewSectionReader(file, start, size), size)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt program bundle: %v", err)
+	}
+	a := &app{files: files}
+	data, err := readZipFile(files, manifestName)
+	if err != nil {
+		return nil, err
+	} else if err := json.Unmarshal(data, &a.manifest); err != nil {
+		return nil, fmt.Errorf("corrupt program bundle: %v", err)
+	}
+	return a, nil
+}
+
+func readZipFile(files *zip.Reader, name string) ([]byte, error) {
+	file, err := files.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt program bundle: %v", err)
+	}
+	defer file.Close()
+	return ioutil.ReadAll(file)
+}
+
+// run runs the bundled program with all of the arguments bound to *ARGV*
+func (a *app) run(args []string) int {
+	core.RegisterLibrary(core.Library{FS: a.files})
+	defaultEnv := core.DefaultNamespace()
+	loadPaths := []string{}
+	for _, dir := range a.LoadPath {
+		loadPaths = append(loadPaths, core.LibraryPath(dir))
+	}
+	setup(defaultEnv, loadPaths, args)
+	if err := runFile(defaultEnv, core.LibraryPath(a.Main)); err != nil {
+		return uncaught(err)
+	}
+	return exitOK
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/tanema/mal/src/core"
+	"github.com/tanema/mal/src/env"
+	"github.com/tanema/mal/src/reader"
+	"github.com/tanema/mal/src/runtime"
+	"github.com/tanema/mal/src/types"
+)
+
+// completer provides tab completion and inline docs for the REPL. It completes
+// symbols from the environment, special forms and keywords, and file paths
+// when the cursor is inside of a string.
+type completer struct {
+	env *env.Env
+}
+
+func isSymbolRune(r rune) bool {
+	return !unicode.IsSpace(r) && !strings.ContainsRune("()[]{}\"'`~@^,;", r)
+}
+
+func (c *completer) complete(line []rune, pos int) (int, []string) {
+	if start, inString := stringStart(line, pos); inString {
+		return start, completePath(string(line[start:pos]))
+	}
+	start := pos
+	for start > 0 && isSymbolRune(line[start-1]) {
+		start--
+	}
+	word := string(line[start:pos])
+	if word == "" {
+		return start, nil
+	}
+	candidates := []string{}
+	if strings.HasPrefix(word, ":") {
+		for _, kw := range reader.Keywords() {
+			if strings.HasPrefix(":"+string(kw), word) {
+				candidates = append(candidates, ":"+string(kw))
+			}
+		}
+		return start, candidates
+	}
+	symbols := append(c.env.Current().Symbols(), runtime.SpecialForms...)
+	seen := map[types.Symbol]bool{}
+	for _, sym := range symbols {
+		if !seen[sym] && strings.HasPrefix(string(sym), word) {
+			candidates = append(candidates, string(sym))
+		}
+		seen[sym] = true
+	}
+	return start, candidates
+}
+
+// stringStart checks if the cursor is inside of a string literal and if so it
+// returns the position of the first character inside the quotes
+func stringStart(line []rune, pos int) (int, bool) {
+	start, inString := 0, false
+	for i := 0; i < pos; i++ {
+		switch {
+		case inString && line[i] == '\\':
+			i++
+		case line[i] == '"':
+			inString, start = !inString, i+1
+		case !inString && line[i] == ';':
+			return 0, false
+		}
+	}
+	return start, inString
+}
+
+func completePath(word string) []string {
+	dir, base := filepath.Split(word)
+	searchDir := dir
+	if searchDir == "" {
+		searchDir = "."
+	}
+	files, err := ioutil.ReadDir(searchDir)
+	if err != nil {
+		return nil
+	}
+	candidates := []string{}
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), base) {
+			continue
+		}
+		path := dir + file.Name()
+		if file.IsDir() {
+			path += string(filepath.Separator)
+		}
+		candidates = append(candidates, path)
+	}
+	return candidates
+}
+
+// document finds the symbol under the cursor, or the function being called if
+// the cursor is not on a symbol, and describes its value
+func (c *completer) document(line []rune, pos int) string {
+	name := symbolAt(line, pos)
+	if name == "" {
+		return ""
+	}
+	if desc, isSpecial := core.DescribeSpecialForm(types.Symbol(name)); isSpecial {
+		return desc
+	}
+	val, err := c.env.Current().Get(types.Symbol(name))
+	if err != nil {
+		return ""
+	}
+	return core.Describe(name, val)
+}
+
+func symbolAt(line []rune, pos int) string {
+	start, end := pos, pos
+	for start > 0 && isSymbolRune(line[start-1]) {
+		start--
+	}
+	for end < len(line) && isSymbolRune(line[end]) {
+		end++
+	}
+	if start < end {
+		return string(line[start:end])
+	}
+	depth := 0
+	for i := pos - 1; i >= 0; i-- {
+		switch line[i] {
+		case ')':
+			depth++
+		case '(':
+			if depth > 0 {
+				depth--
+				continue
+			}
+			end = i + 1
+			for end < len(line) && isSymbolRune(line[end]) {
+				end++
+			}
+			return string(line[i+1 : end])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tanema/mal/src/core"
+	"github.com/tanema/mal/src/readline"
+)
+
+// malTest is a form from a test file along with the output that is expected
+// when it is entered into the REPL. Output lines are regular expressions and the
+// return value is matched exactly. When both are empty the result is ignored.
+type malTest struct {
+	line   int
+	form   string
+	output []string
+	ret    string
+	soft   bool
+}
+
+// parseMalTests reads the test format used by the mal project. Forms are
+// followed by ;/ lines that match output and a ;=> line with the printed result.
+// Lines starting with ;;; are comments and ;; lines are section headings.
+func parseMalTests(path string) ([]malTest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	tests := []malTest{}
+	soft := false
+	var current *malTest
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		switch {
+		case current != nil && strings.HasPrefix(line, ";=>"):
+			current.ret = line[3:]
+			current = nil
+			continue
+		case current != nil && strings.HasPrefix(line, ";/"):
+			current.output = append(current.output, line[2:])
+			continue
+		}
+		current = nil
+		switch {
+		case strings.TrimSpace(line) == "", strings.HasPrefix(line, ";;"):
+		case strings.HasPrefix(line, ";>>> "):
+			settings := strings.Replace(line[5:], " ", "", -1)
+			if strings.Contains(settings, "soft=True") {
+				soft = true
+			} else if strings.Contains(settings, "soft=False") {
+				soft = false
+			}
+		case strings.HasPrefix(line, ";"):
+			return nil, fmt.Errorf("%v:%v: unexpected comment %q", path, lineNum, line)
+		default:
+			tests = append(tests, malTest{line: lineNum, form: line, soft: soft})
+			current = &tests[len(tests)-1]
+		}
+	}
+	return tests, scanner.Err()
+}
+
+// pattern builds the expression that the REPL output has to match
+func (test malTest) pattern() *regexp.Regexp {
+	output := ""
+	for _, line := range test.output {
+		output += line + "\n"
+	}
+	if test.ret == "" {
+		return regexp.MustCompile("(?s)^" + strings.TrimSuffix(output, "\n"))
+	}
+	return regexp.MustCompile("(?s)^" + output + regexp.QuoteMeta(test.ret) + "\n")
+}
+
+// testInput feeds the forms after the one being evaluated to readline, one line
+// at a time, so that a form that reads input consumes the following test lines
+// like it would in the REPL. The output written before each line is read belongs
+// to the tests before it.
+type testInput struct {
+	tests   []malTest
+	next    int
+	pending string
+	out     *os.File
+	mark    int64
+}
+
+func (in *testInput) Read(p []byte) (int, error) {
+	if in.pending == "" {
+		if in.next >= len(in.tests) {
+			return 0, io.EOF
+		}
+		in.pending = in.tests[in.next].form + "\n"
+		in.next++
+		in.mark, _ = in.out.Seek(0, io.SeekCurrent)
+	}
+	n := copy(p, in.pending)
+	in.pending = in.pending[n:]
+	return n, nil
+}
+
+// rep enters a line into the REPL and returns everything that was printed
+// after the last line of input that it read
+func (in *testInput) rep(r *repl, line string) string {
+	in.out.Truncate(0)
+	in.out.Seek(0, io.SeekStart)
+	in.mark = 0
+	stdout := os.Stdout
+	os.Stdout = in.out
+	r.rep(line)
+	os.Stdout = stdout
+	// time-ms only changes every millisecond and the tests expect time to pass
+	// between forms like it does when they are typed into the REPL
+	time.Sleep(time.Millisecond)
+	in.out.Seek(in.mark, io.SeekStart)
+	output, _ := ioutil.ReadAll(in.out)
+	return string(output)
+}
+
+func TestFinal(t *testing.T) {
+	testFile, err := filepath.Abs("../../test/final.mal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests, err := parseMalTests(testFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.TempFile("", "wot-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	// the tests load files relative to the root of the repository
+	wd, _ := os.Getwd()
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	in := &testInput{tests: tests, out: out}
+	readline.SetInput(in)
+	defer readline.SetInput(nil)
+
+	defaultEnv := core.DefaultNamespace()
+	setup(defaultEnv, nil, nil)
+	r := &repl{running: true, completions: &completer{}}
+	r.setup(defaultEnv)
+	// the mal tests expect every result on one line however long it is
+	defaultEnv.Set("*print-right-margin*", float64(math.MaxInt32))
+	for in.next < len(tests) {
+		test := tests[in.next]
+		in.next++
+		output := in.rep(r, test.form)
+		// the result belongs to the last test line that was read
+		test = tests[in.next-1]
+		if test.ret == "" && len(test.output) == 0 {
+			continue
+		} else if !test.pattern().MatchString(output) {
+			report := t.Errorf
+			if test.soft {
+				report = t.Logf
+			}
+			report("final.mal:%v: %v\n  expected output: %q\n  expected result: %v\n  got: %q", test.line, test.form, test.output, test.ret, output)
+		}
+	}
+}
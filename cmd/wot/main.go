@@ -1,69 +1,251 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
 
 	"github.com/tanema/mal/src/core"
 	"github.com/tanema/mal/src/env"
+	"github.com/tanema/mal/src/image"
 	"github.com/tanema/mal/src/printer"
 	"github.com/tanema/mal/src/reader"
-	"github.com/tanema/mal/src/readline"
 	"github.com/tanema/mal/src/runtime"
 	"github.com/tanema/mal/src/types"
 )
 
+var version = "0.1.0"
+
+const usage = `Usage: wot [options] [file | -] [args...]
+       wot build [-o output] [-I dir] file
+       wot test [--junit file] [-I dir] [dir | file]...
+
+Runs the file, or the script read from stdin when - is given, with any
+following args bound to *ARGV*. With no file or expressions a REPL is started.
+
+Options:
+  -e <expr>            evaluate the forms in the expression and print the
+                       result of each, can be repeated
+  -i                   start a REPL after loading the files and expressions
+  -I <dir>             add a directory to *load-path*, can be repeated
+  --allow-path <dir>   only let slurp, load-file, require and the io functions
+                       use files in the directory, can be repeated
+  --image <file>       start from the environment saved in the image
+  --save-image <file>  save the environment to the image after loading the
+                       files and expressions
+  --version            print the version and exit
+  --help               print this help and exit
+
+Commands:
+  build                write an executable that runs the file, see wot build --help
+  test                 run the tests in the *_test.mal files, see wot test --help
+`
+
+// exit codes returned by the process
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// stringList is a flag that can be given more than once
+type stringList []string
+
+func (l *stringList) String() string       { return strings.Join(*l, ",") }
+func (l *stringList) Set(val string) error { *l = append(*l, val); return nil }
+
+type options struct {
+	exprs       stringList
+	loadPaths   stringList
+	allowPaths  stringList
+	interactive bool
+	image       string
+	saveImage   string
+	version     bool
+	help        bool
+}
+
 func main() {
-	defaultEnv := core.DefaultNamespace()
-	if len(os.Args) > 1 {
-		runFile(defaultEnv, os.Args[1], os.Args[2:]...)
+	if program, err := openApp(); err != nil {
+		os.Exit(uncaught(err))
+	} else if program != nil {
+		os.Exit(program.run(os.Args[1:]))
+	}
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) > 0 && args[0] == "build" {
+		return runBuild(args[1:])
+	} else if len(args) > 0 && args[0] == "test" {
+		return runTests(args[1:])
+	}
+	var opts options
+	flags := flag.NewFlagSet("wot", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	flags.Var(&opts.exprs, "e", "")
+	flags.Var(&opts.loadPaths, "I", "")
+	flags.Var(&opts.allowPaths, "allow-path", "")
+	flags.BoolVar(&opts.interactive, "i", false, "")
+	flags.StringVar(&opts.image, "image", "", "")
+	flags.StringVar(&opts.saveImage, "save-image", "", "")
+	flags.BoolVar(&opts.version, "version", false, "")
+	flags.BoolVar(&opts.help, "help", false, "")
+	if err := flags.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "wot: %v\n\n%v", err, usage)
+		return exitUsage
+	}
+	if opts.help {
+		fmt.Print(usage)
+		return exitOK
+	} else if opts.version {
+		fmt.Printf("wot %v\n", version)
+		return exitOK
+	}
+
+	rest := flags.Args()
+	var script string
+	var argv []string
+	if len(rest) > 0 {
+		script, argv = rest[0], rest[1:]
+	}
+	var defaultEnv *env.Env
+	if opts.image != "" {
+		var err error
+		if defaultEnv, err = loadImage(opts.image, opts.loadPaths, argv); err != nil {
+			return uncaught(err)
+		}
 	} else {
+		defaultEnv = core.DefaultNamespace()
+		setup(defaultEnv, opts.loadPaths, argv)
+	}
+	if err := core.SetAllowedPaths(defaultEnv, opts.allowPaths...); err != nil {
+		return uncaught(err)
+	}
+
+	for _, expr := range opts.exprs {
+		if err := runExpr(defaultEnv, expr); err != nil {
+			return uncaught(err)
+		}
+	}
+	if script == "-" {
+		if err := runStdin(defaultEnv); err != nil {
+			return uncaught(err)
+		}
+	} else if script != "" {
+		if err := runFile(defaultEnv, script); err != nil {
+			return uncaught(err)
+		}
+	}
+	if opts.saveImage != "" {
+		if err := saveImage(defaultEnv, opts.saveImage); err != nil {
+			return uncaught(err)
+		}
+	}
+	if opts.interactive || (script == "" && len(opts.exprs) == 0 && opts.saveImage == "") {
 		runREPL(defaultEnv)
 	}
+	return exitOK
 }
 
-func runFile(e *env.Env, path string, argv ...string) {
+// setup binds the command line arguments and gives scripts an exit function that
+// ends the process with the exit code given
+func setup(e *env.Env, loadPaths, argv []string) {
 	targv := make([]types.Base, len(argv))
 	for i, arg := range argv {
 		targv[i] = types.Base(arg)
 	}
 	e.Set("*ARGV*", types.NewList(targv...))
-	if _, err := rep(`(load-file "`+os.Args[1]+`")`, e); err != nil {
-		fmt.Println(printer.Print(err, true))
+	paths := make([]types.Base, len(loadPaths))
+	for i, path := range loadPaths {
+		paths[i] = path
 	}
-}
-
-func runREPL(env *env.Env) error {
-	keepRunning := true
-	env.Set("*ARGV*", types.NewList())
-	env.Set("exit", types.Func(func(e types.Env, a []types.Base) (types.Base, error) {
-		keepRunning = false
+	e.Set("*load-path*", types.NewVect(paths...))
+	e.Set("exit", types.Func(func(e types.Env, a []types.Base) (types.Base, error) {
+		code := exitOK
+		if len(a) > 0 {
+			if num, isNum := a[0].(float64); isNum {
+				code = int(num)
+			}
+		}
+		os.Exit(code)
 		return nil, nil
 	}))
-	for keepRunning {
-		text, err := readline.Readline("user> ")
-		if err != nil {
-			fmt.Println(printer.Print(err, true))
-			continue
-		}
-		valStr, err := rep(text, env)
-		if err != nil {
-			fmt.Println(printer.Print(err, true))
-			continue
+}
+
+// loadImage starts from the environment saved in an image. The command line is
+// set up before loading so that the image can use exit, and again after so that
+// *ARGV* and *load-path* are the ones given to this run.
+func loadImage(path string, loadPaths, argv []string) (*env.Env, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	defaultEnv := core.BuiltinNamespace()
+	setup(defaultEnv, loadPaths, argv)
+	if err := image.Load(file, defaultEnv); err != nil {
+		return nil, fmt.Errorf("could not load image %v: %v", path, err)
+	}
+	setup(defaultEnv, loadPaths, argv)
+	return defaultEnv, nil
+}
+
+func saveImage(e *env.Env, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := image.Save(file, e); err != nil {
+		file.Close()
+		os.Remove(path)
+		return fmt.Errorf("could not save image %v: %v", path, err)
+	}
+	return file.Close()
+}
+
+func uncaught(err error) int {
+	fmt.Fprintln(os.Stderr, printer.Print(err, true))
+	return exitError
+}
+
+func runFile(e *env.Env, path string) error {
+	_, err := runtime.Eval(e, types.NewList(types.Symbol("load-file"), path))
+	return err
+}
+
+func runStdin(e *env.Env) error {
+	source, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	forms, err := reader.ReadAll(string(source))
+	if err != nil {
+		return err
+	}
+	for _, form := range forms {
+		if _, err := runtime.Eval(e.Current(), form); err != nil {
+			return err
 		}
-		fmt.Println(valStr)
 	}
 	return nil
 }
 
-func rep(in string, e *env.Env) (string, error) {
-	ast, parseErr := reader.ReadString(in)
-	if parseErr != nil {
-		return "", parseErr
+// runExpr evaluates every form in an expression given with -e and prints the
+// result of each one
+func runExpr(e *env.Env, expr string) error {
+	forms, err := reader.ReadAll(expr)
+	if err != nil {
+		return err
 	}
-	val, evalErr := runtime.Eval(e, ast)
-	if evalErr != nil {
-		return "", evalErr
+	for _, form := range forms {
+		val, err := runtime.Eval(e.Current(), form)
+		if err != nil {
+			return err
+		}
+		fmt.Println(core.PrintOptions(e.Current()).Print(val, true))
 	}
-	return printer.Print(val, true), nil
+	return nil
 }
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/tanema/mal/src/core"
+)
+
+// TestRunExpr checks that every form in an expression given with -e is
+// evaluated and has its result printed
+func TestRunExpr(t *testing.T) {
+	out, err := ioutil.TempFile(t.TempDir(), "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	stdout := os.Stdout
+	os.Stdout = out
+	err = runExpr(core.DefaultNamespace(), `(def! a 1) (+ a 1) "done"`)
+	os.Stdout = stdout
+	if err != nil {
+		t.Fatal(err)
+	}
+	if printed, _ := ioutil.ReadFile(out.Name()); string(printed) != "1\n2\n\"done\"\n" {
+		t.Errorf("printed %q, expected the result of every form", printed)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tanema/mal/src/core"
+	"github.com/tanema/mal/src/env"
+	"github.com/tanema/mal/src/printer"
+	"github.com/tanema/mal/src/reader"
+	"github.com/tanema/mal/src/readline"
+	"github.com/tanema/mal/src/runtime"
+	"github.com/tanema/mal/src/types"
+)
+
+const replHelp = `REPL commands:
+  :load <file>    load and evaluate a file
+  :time <form>    evaluate the form and print how long it took
+  :expand <form>  print the full macro expansion of the form
+  :env [prefix]   list the global bindings
+  :reset          start over with a fresh environment
+  :help           show this help
+  :quit           leave the REPL
+
+*1, *2 and *3 hold the last three results and *e holds the last exception.`
+
+// repl holds the state of an interactive session so that the environment can be
+// swapped out with :reset
+type repl struct {
+	env         *env.Env
+	running     bool
+	completions *completer
+}
+
+func runREPL(e *env.Env) error {
+	r := &repl{running: true, completions: &completer{}}
+	r.setup(e)
+	readline.SetCompleter(r.completions.complete)
+	readline.SetDocumenter(r.completions.document)
+	for r.running {
+		line, err := readline.Readline(r.prompt())
+		if err == io.EOF {
+			break
+		} else if err == readline.ErrInterrupt {
+			continue
+		} else if err != nil {
+			fmt.Println(printer.Print(err, true))
+			continue
+		}
+		if r.rep(line) == io.EOF {
+			break
+		}
+	}
+	return nil
+}
+
+// rep runs a REPL command or reads the forms started on the line, along with
+// any continuation lines they need, and evaluates them. It returns io.EOF when
+// the input ends in the middle of a form.
+func (r *repl) rep(line string) error {
+	if cmd, arg, isCmd := parseCommand(line); isCmd {
+		if err := r.command(cmd, arg); err != nil {
+			r.fail(err)
+		}
+		return nil
+	}
+	forms, err := readForms(line)
+	if err == io.EOF {
+		return err
+	} else if err == readline.ErrInterrupt {
+		return nil
+	}
+	// the forms before one that cannot be read are still evaluated
+	if r.evalForms(forms) && err != nil {
+		r.fail(err)
+	}
+	return nil
+}
+
+// prompt shows the name of the current namespace
+func (r *repl) prompt() string {
+	return string(r.env.Namespace().Registry().Current().Name) + "> "
+}
+
+func (r *repl) setup(e *env.Env) {
+	r.env = e
+	r.completions.env = e
+	e.Set("exit", types.Func(func(e types.Env, a []types.Base) (types.Base, error) {
+		r.running = false
+		return nil, nil
+	}))
+	for _, name := range []types.Symbol{"*1", "*2", "*3", "*e"} {
+		e.Set(name, nil)
+	}
+}
+
+// evalForms evaluates and prints each form, shifting the result history
+// variables along as it goes. It stops at the first error or exit and returns
+// whether every form was evaluated.
+func (r *repl) evalForms(forms []types.Base) bool {
+	for _, form := range forms {
+		val, err := runtime.Eval(r.env.Current(), form)
+		if err != nil {
+			r.fail(err)
+			return false
+		}
+		r.remember(val)
+		fmt.Println(core.PrintOptions(r.env.Current()).Pretty(val, core.RightMargin(r.env.Current()), false))
+		if !r.running {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *repl) remember(val types.Base) {
+	second, _ := r.env.Get("*2")
+	first, _ := r.env.Get("*1")
+	r.env.Set("*3", second)
+	r.env.Set("*2", first)
+	r.env.Set("*1", val)
+}
+
+// fail prints the error and binds it to *e, a thrown value is bound as is the
+// same way that catch* would bind it
+func (r *repl) fail(err error) {
+	var val types.Base = err.Error()
+	if userErr, isUserErr := err.(types.UserError); isUserErr {
+		val = userErr.Val
+	}
+	r.env.Set("*e", val)
+	fmt.Println(printer.Print(err, true))
+}
+
+func parseCommand(line string) (string, string, bool) {
+	line = strings.TrimSpace(line)
+	parts := strings.SplitN(line, " ", 2)
+	switch parts[0] {
+	case ":load", ":time", ":expand", ":env", ":reset", ":help", ":quit":
+	default:
+		return "", "", false
+	}
+	arg := ""
+	if len(parts) > 1 {
+		arg = strings.TrimSpace(parts[1])
+	}
+	return parts[0], arg, true
+}
+
+func (r *repl) command(cmd, arg string) error {
+	switch cmd {
+	case ":load":
+		if arg == "" {
+			return fmt.Errorf(":load expects a file path")
+		}
+		_, err := runtime.Eval(r.env.Current(), types.NewList(types.Symbol("load-file"), arg))
+		return err
+	case ":time":
+		forms, err := readForms(arg)
+		if err != nil {
+			return err
+		}
+		start := time.Now()
+		r.evalForms(forms)
+		fmt.Printf("Elapsed time: %v\n", time.Since(start))
+	case ":expand":
+		forms, err := readForms(arg)
+		if err != nil {
+			return err
+		}
+		for _, form := range forms {
+			expanded, err := r.expand(form)
+			if err != nil {
+				return err
+			}
+			fmt.Println(core.PrintOptions(r.env.Current()).Pretty(expanded, core.RightMargin(r.env.Current()), true))
+		}
+	case ":env":
+		names := []string{}
+		for _, sym := range r.env.Current().Symbols() {
+			if strings.HasPrefix(string(sym), arg) {
+				names = append(names, string(sym))
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			val, _ := r.env.Current().Get(types.Symbol(name))
+			fmt.Printf("%v = %v\n", name, summary(val))
+		}
+	case ":reset":
+		fresh := core.DefaultNamespace()
+		for _, name := range []types.Symbol{"*ARGV*", "*load-path*"} {
+			val, _ := r.env.Get(name)
+			fresh.Set(name, val)
+		}
+		fresh.Namespace().Registry().AllowedPaths = r.env.Namespace().Registry().AllowedPaths
+		r.setup(fresh)
+	case ":help":
+		fmt.Println(replHelp)
+	case ":quit":
+		r.running = false
+	}
+	return nil
+}
+
+// expand will macroexpand the form and then every form nested inside of it
+// that is evaluated. Quoted forms, the names bound by def!, let* and catch* and
+// the parameters of fn* are left alone, and vectors and maps keep their type
+// with only their items expanded.
+func (r *repl) expand(form types.Base) (types.Base, error) {
+	switch tform := form.(type) {
+	case *types.Vector:
+		forms, err := r.expandFrom(tform.Forms, 0, 1)
+		if err != nil {
+			return nil, err
+		}
+		return types.NewVect(forms...), nil
+	case *types.Hashmap:
+		forms, err := r.expandFrom(tform.ToList(), 1, 2)
+		if err != nil {
+			return nil, err
+		}
+		return types.NewHashmap(forms)
+	case *types.List:
+	default:
+		return form, nil
+	}
+	expanded, err := runtime.Eval(r.env.Current(), types.NewList(types.Symbol("macroexpand"), form))
+	if err != nil {
+		return nil, err
+	}
+	list, isList := expanded.(*types.List)
+	if !isList {
+		return r.expand(expanded)
+	} else if len(list.Forms) == 0 {
+		return list, nil
+	}
+	var forms []types.Base
+	switch list.Forms[0] {
+	case types.Symbol("quote"), types.Symbol("quasiquote"):
+		return list, nil
+	case types.Symbol("def!"), types.Symbol("defmacro!"), types.Symbol("fn*"), types.Symbol("catch*"):
+		forms, err = r.expandFrom(list.Forms, 2, 1)
+	case types.Symbol("let*"):
+		forms, err = r.expandFrom(list.Forms, 2, 1)
+		if err == nil && len(forms) > 1 {
+			forms[1], err = r.expandBindings(forms[1])
+		}
+	default:
+		forms, err = r.expandFrom(list.Forms, 0, 1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return types.NewList(forms...), nil
+}
+
+// expandFrom expands every step forms starting from the form at start, like the
+// values of a map, and leaves the rest as they are
+func (r *repl) expandFrom(forms []types.Base, start, step int) ([]types.Base, error) {
+	expanded := append([]types.Base{}, forms...)
+	for i := start; i < len(expanded); i += step {
+		var err error
+		if expanded[i], err = r.expand(expanded[i]); err != nil {
+			return nil, err
+		}
+	}
+	return expanded, nil
+}
+
+// expandBindings expands the values of the bindings of a let* but not the names
+// that they are bound to
+func (r *repl) expandBindings(bindings types.Base) (types.Base, error) {
+	switch tbindings := bindings.(type) {
+	case *types.Vector:
+		forms, err := r.expandFrom(tbindings.Forms, 1, 2)
+		if err != nil {
+			return nil, err
+		}
+		return types.NewVect(forms...), nil
+	case *types.List:
+		forms, err := r.expandFrom(tbindings.Forms, 1, 2)
+		if err != nil {
+			return nil, err
+		}
+		return types.NewList(forms...), nil
+	default:
+		return bindings, nil
+	}
+}
+
+// summary prints a value on a single line, cutting it short if it is too long to
+// be useful in a listing
+func summary(val types.Base) string {
+	str := strings.Replace(printer.Print(val, true), "\n", " ", -1)
+	if runes := []rune(str); len(runes) > 60 {
+		return string(runes[:57]) + "..."
+	}
+	return str
+}
+
+// readForms will keep reading lines, showing a continuation prompt, until all of
+// the forms that have been started in source are complete.
+func readForms(source string) ([]types.Base, error) {
+	source += "\n"
+	for {
+		forms, err := reader.ReadAll(source)
+		if err != reader.ErrUnderflow && err != reader.ErrUnterminatedString {
+			return forms, err
+		}
+		text, err := readline.Readline(" ...> ")
+		if err != nil {
+			return nil, err
+		}
+		source += text + "\n"
+	}
+}
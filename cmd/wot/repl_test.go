@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/tanema/mal/src/core"
+	"github.com/tanema/mal/src/printer"
+	"github.com/tanema/mal/src/reader"
+)
+
+// TestExpand checks that :expand only expands the forms that are evaluated
+func TestExpand(t *testing.T) {
+	r := &repl{running: true, completions: &completer{}}
+	r.setup(core.DefaultNamespace())
+	cases := map[string]string{
+		"(-> 1 inc)":                        "(inc 1)",
+		"(let* [x (-> 1 inc)] (-> x inc))":  "(let* [x (inc 1)] (inc x))",
+		"(let* (-> (-> 1 inc)) ->)":         "(let* (-> (inc 1)) ->)",
+		"(fn* (-> a) (-> a inc))":           "(fn* (-> a) (inc a))",
+		"(defn f [x] (-> x inc))":           "(def! f (fn* [x] (do (inc x))))",
+		"(try* 1 (catch* e (-> e inc)))":    "(try* 1 (catch* e (inc e)))",
+		"'(-> 1 inc)":                       "(quote (-> 1 inc))",
+		"{:a (-> 1 inc) :b [(-> 2 inc) 3]}": "{:a (inc 1) :b [(inc 2) 3]}",
+	}
+	for src, expected := range cases {
+		form, err := reader.ReadString(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expanded, err := r.expand(form); err != nil || printer.Print(expanded, true) != expected {
+			t.Errorf(":expand %v = %v, %v, expected %v", src, printer.Print(expanded, true), err, expected)
+		}
+	}
+}
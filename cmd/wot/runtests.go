@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tanema/mal/src/core"
+	"github.com/tanema/mal/src/printer"
+	"github.com/tanema/mal/src/runtime"
+	"github.com/tanema/mal/src/types"
+)
+
+const testUsage = `Usage: wot test [options] [dir | file]...
+
+Loads every *_test.mal file in the directories, or the working directory when
+none are given, and runs the tests defined with wot.test. The exit code is not
+zero when a test fails or a file cannot be loaded.
+
+Options:
+  --junit <file>  write the results to the file as JUnit XML
+  -I <dir>        add a directory to *load-path*, can be repeated
+`
+
+// testFileSuffix is the suffix of the files that wot test loads
+const testFileSuffix = "_test.mal"
+
+// loadFailure is a test file that could not be loaded
+type loadFailure struct {
+	path string
+	err  error
+}
+
+func runTests(args []string) int {
+	var junit string
+	var loadPaths stringList
+	flags := flag.NewFlagSet("wot test", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	flags.StringVar(&junit, "junit", "", "")
+	flags.Var(&loadPaths, "I", "")
+	paths, err := parseInterspersed(flags, args)
+	if err == flag.ErrHelp {
+		fmt.Print(testUsage)
+		return exitOK
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "wot: %v\n\n%v", err, testUsage)
+		return exitUsage
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files, err := findTestFiles(paths)
+	if err != nil {
+		return uncaught(err)
+	}
+
+	defaultEnv := core.DefaultNamespace()
+	setup(defaultEnv, loadPaths, nil)
+	if _, err := runtime.Eval(defaultEnv.Current(), types.NewList(types.Symbol("require"), types.NewList(types.Symbol("quote"), types.Symbol("wot.test")))); err != nil {
+		return uncaught(err)
+	}
+	failures := []loadFailure{}
+	for _, file := range files {
+		if err := runFile(defaultEnv, file); err != nil {
+			fmt.Fprintf(os.Stderr, "\nERROR loading %v\n%v\n", file, printer.Print(err, true))
+			failures = append(failures, loadFailure{path: file, err: err})
+		}
+	}
+	val, err := runtime.Eval(defaultEnv.Current(), types.NewList(types.Symbol("wot.test/run-all-tests")))
+	if err != nil {
+		return uncaught(err)
+	}
+	summary, _ := val.(*types.Hashmap)
+	if summary == nil {
+		return uncaught(fmt.Errorf("unexpected test summary %v", printer.Print(val, true)))
+	}
+	if junit != "" {
+		if err := writeJUnit(junit, summary, failures); err != nil {
+			return uncaught(err)
+		}
+	}
+	if len(failures) > 0 || field(summary, "fail") != 0.0 || field(summary, "error") != 0.0 {
+		return exitError
+	}
+	return exitOK
+}
+
+// findTestFiles finds the test files in the paths in lexical order. Files that
+// are given directly are used even if they do not end in _test.mal.
+func findTestFiles(paths []string) ([]string, error) {
+	files := []string{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		} else if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			} else if info.IsDir() && file != path && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			} else if !info.IsDir() && strings.HasSuffix(info.Name(), testFileSuffix) {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func field(hm *types.Hashmap, key string) types.Base {
+	return hm.Forms[types.Keyword(key)]
+}
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+	secs     float64
+}
+
+type junitCase struct {
+	Name      string         `xml:"name,attr"`
+	Classname string         `xml:"classname,attr"`
+	Time      string         `xml:"time,attr"`
+	Failures  []junitFailure `xml:"failure"`
+	Errors    []junitFailure `xml:"error"`
+	secs      float64
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit writes the results of a test run with a test suite for each
+// namespace, and one for each file that could not be loaded
+func writeJUnit(path string, summary *types.Hashmap, failures []loadFailure) error {
+	report := junitSuites{}
+	suites := map[string]*junitSuite{}
+	order := []string{}
+	results, _ := field(summary, "results").(types.Collection)
+	if results != nil {
+		for _, val := range results.Data() {
+			result, ok := val.(*types.Hashmap)
+			if !ok {
+				continue
+			}
+			ns := printer.Print(field(result, "ns"), false)
+			suite, found := suites[ns]
+			if !found {
+				suite = &junitSuite{Name: ns}
+				suites[ns] = suite
+				order = append(order, ns)
+			}
+			suite.add(junitTestCase(ns, result))
+		}
+	}
+	for _, ns := range order {
+		report.add(*suites[ns])
+	}
+	for _, failure := range failures {
+		suite := junitSuite{Name: failure.path}
+		suite.add(junitCase{
+			Name:      "load",
+			Classname: failure.path,
+			Time:      "0.000",
+			Errors:    []junitFailure{{Message: "could not load file", Text: printer.Print(failure.err, true)}},
+		})
+		report.add(suite)
+	}
+	data, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append([]byte(xml.Header), append(data, '\n')...), 0644)
+}
+
+func junitTestCase(ns string, result *types.Hashmap) junitCase {
+	secs := seconds(field(result, "time"))
+	testCase := junitCase{
+		Name:      printer.Print(field(result, "name"), false),
+		Classname: ns,
+		Time:      fmt.Sprintf("%.3f", secs),
+		secs:      secs,
+	}
+	failures, _ := field(result, "failures").(types.Collection)
+	if failures == nil {
+		return testCase
+	}
+	for _, val := range failures.Data() {
+		failure, ok := val.(*types.Hashmap)
+		if !ok {
+			continue
+		}
+		jf := junitFailure{Text: describeFailure(failure)}
+		if msg, ok := field(failure, "message").(string); ok {
+			jf.Message = msg
+		} else {
+			jf.Message = printer.Print(field(failure, "expected"), true)
+		}
+		if field(failure, "type") == types.Keyword("error") {
+			testCase.Errors = append(testCase.Errors, jf)
+		} else {
+			testCase.Failures = append(testCase.Failures, jf)
+		}
+	}
+	return testCase
+}
+
+func describeFailure(failure *types.Hashmap) string {
+	lines := []string{}
+	if contexts, ok := field(failure, "contexts").(string); ok && contexts != "" {
+		lines = append(lines, contexts)
+	}
+	lines = append(lines,
+		"expected: "+printer.Print(field(failure, "expected"), true),
+		"  actual: "+printer.Print(field(failure, "actual"), true))
+	return strings.Join(lines, "\n")
+}
+
+func seconds(ms types.Base) float64 {
+	num, _ := ms.(float64)
+	return num / 1000
+}
+
+func (suite *junitSuite) add(testCase junitCase) {
+	suite.Cases = append(suite.Cases, testCase)
+	suite.Tests++
+	if len(testCase.Errors) > 0 {
+		suite.Errors++
+	} else if len(testCase.Failures) > 0 {
+		suite.Failures++
+	}
+	suite.secs += testCase.secs
+	suite.Time = fmt.Sprintf("%.3f", suite.secs)
+}
+
+func (report *junitSuites) add(suite junitSuite) {
+	report.Suites = append(report.Suites, suite)
+	report.Tests += suite.Tests
+	report.Failures += suite.Failures
+	report.Errors += suite.Errors
+}
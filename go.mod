@@ -1,5 +1,5 @@
 module github.com/tanema/mal
 
-go 1.12
+go 1.18
 
 require github.com/tanema/mal/wotlisp v0.0.0-20190414230528-e8352ea3a952
@@ -0,0 +1,10 @@
+// Package lib holds the wot standard library. The sources are embedded so that
+// the interpreter does not need any .mal files on disk to run.
+package lib
+
+import "embed"
+
+// FS contains the standard library namespaces, wot.core is found in wot/core.mal
+//
+//go:embed wot
+var FS embed.FS
@@ -3,78 +3,106 @@ package core
 import (
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
 	"reflect"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/tanema/mal/src/env"
 	"github.com/tanema/mal/src/printer"
 	"github.com/tanema/mal/src/reader"
 	"github.com/tanema/mal/src/readline"
+	"github.com/tanema/mal/src/runtime"
 	"github.com/tanema/mal/src/types"
 )
 
 var namespace = map[types.Symbol]*types.StdFunc{
-	"+":           types.Func(add),
-	"-":           types.Func(sub),
-	"*":           types.Func(mul),
-	"/":           types.Func(div),
-	"=":           types.Func(equal),
-	"<":           types.Func(lessThan),
-	"<=":          types.Func(lessThanEqual),
-	">":           types.Func(greaterThan),
-	">=":          types.Func(greaterThanEqual),
-	"prn":         types.Func(prn),
-	"println":     types.Func(prnln),
-	"pr-str":      types.Func(prnstr),
-	"str":         types.Func(str),
-	"list":        types.Func(list),
-	"list?":       types.Func(islist),
-	"empty?":      types.Func(isempty),
-	"count":       types.Func(count),
-	"read-string": types.Func(readString),
-	"slurp":       types.Func(slurp),
-	"atom":        types.Func(atom),
-	"atom?":       types.Func(isatom),
-	"deref":       types.Func(deref),
-	"reset!":      types.Func(reset),
-	"swap!":       types.Func(swap),
-	"cons":        types.Func(cons),
-	"concat":      types.Func(concat),
-	"nth":         types.Func(nth),
-	"first":       types.Func(first),
-	"rest":        types.Func(rest),
-	"throw":       types.Func(throw),
-	"apply":       types.Func(apply),
-	"map":         types.Func(mapvals),
-	"nil?":        types.Func(isnil),
-	"true?":       types.Func(istrue),
-	"false?":      types.Func(isfalse),
-	"symbol?":     types.Func(issymbol),
-	"symbol":      types.Func(makesymbol),
-	"keyword?":    types.Func(iskeyword),
-	"keyword":     types.Func(makekeyword),
-	"vector?":     types.Func(isvector),
-	"vector":      types.Func(makevector),
-	"map?":        types.Func(ismap),
-	"hash-map":    types.Func(makemap),
-	"assoc":       types.Func(assoc),
-	"dissoc":      types.Func(dissoc),
-	"get":         types.Func(get),
-	"contains?":   types.Func(contains),
-	"keys":        types.Func(keys),
-	"vals":        types.Func(vals),
-	"sequential?": types.Func(sequential),
-	"readline":    types.Func(rdline),
-	"meta":        types.Func(meta),
-	"with-meta":   types.Func(withmeta),
-	"string?":     types.Func(isstring),
-	"number?":     types.Func(isnumber),
-	"fn?":         types.Func(isfn),
-	"macro?":      types.Func(ismacro),
-	"conj":        types.Func(conj),
-	"seq":         types.Func(seq),
-	"time-ms":     types.Func(timems),
+	"+":             types.Func(add),
+	"-":             types.Func(sub),
+	"*":             types.Func(mul),
+	"/":             types.Func(div),
+	"=":             types.Func(equal),
+	"<":             types.Func(lessThan),
+	"<=":            types.Func(lessThanEqual),
+	">":             types.Func(greaterThan),
+	">=":            types.Func(greaterThanEqual),
+	"prn":           types.Func(prn),
+	"println":       types.Func(prnln),
+	"pr-str":        types.Func(prnstr),
+	"pprint":        types.Func(pprint),
+	"pprint-str":    types.Func(pprintStr),
+	"str":           types.Func(str),
+	"list":          types.Func(list),
+	"list?":         types.Func(islist),
+	"empty?":        types.Func(isempty),
+	"count":         types.Func(count),
+	"read-string":   types.Func(readString),
+	"slurp":         types.Func(slurp),
+	"atom":          types.Func(atom),
+	"atom?":         types.Func(isatom),
+	"deref":         types.Func(deref),
+	"reset!":        types.Func(reset),
+	"swap!":         types.Func(swap),
+	"cons":          types.Func(cons),
+	"concat":        types.Func(concat),
+	"nth":           types.Func(nth),
+	"first":         types.Func(first),
+	"rest":          types.Func(rest),
+	"throw":         types.Func(throw),
+	"apply":         types.Func(apply),
+	"map":           types.Func(mapvals),
+	"nil?":          types.Func(isnil),
+	"true?":         types.Func(istrue),
+	"false?":        types.Func(isfalse),
+	"symbol?":       types.Func(issymbol),
+	"symbol":        types.Func(makesymbol),
+	"keyword?":      types.Func(iskeyword),
+	"keyword":       types.Func(makekeyword),
+	"vector?":       types.Func(isvector),
+	"vector":        types.Func(makevector),
+	"map?":          types.Func(ismap),
+	"hash-map":      types.Func(makemap),
+	"assoc":         types.Func(assoc),
+	"dissoc":        types.Func(dissoc),
+	"get":           types.Func(get),
+	"contains?":     types.Func(contains),
+	"keys":          types.Func(keys),
+	"vals":          types.Func(vals),
+	"sequential?":   types.Func(sequential),
+	"readline":      types.Func(rdline),
+	"meta":          types.Func(meta),
+	"with-meta":     types.Func(withmeta),
+	"string?":       types.Func(isstring),
+	"number?":       types.Func(isnumber),
+	"char?":         types.Func(ischar),
+	"char":          types.Func(makechar),
+	"int":           types.Func(toint),
+	"fn?":           types.Func(isfn),
+	"macro?":        types.Func(ismacro),
+	"conj":          types.Func(conj),
+	"seq":           types.Func(seq),
+	"time-ms":       types.Func(timems),
+	"print-doc":     types.Func(printdoc),
+	"find-doc":      types.Func(finddoc),
+	"apropos":       types.Func(apropos),
+	"source-fn":     types.Func(sourcefn),
+	"re-pattern":    types.Func(rePattern),
+	"re-matcher":    types.Func(reMatcher),
+	"re-find":       types.Func(reFind),
+	"re-matches":    types.Func(reMatches),
+	"re-seq":        types.Func(reSeq),
+	"re-groups":     types.Func(reGroups),
+	"regex?":        types.Func(isregex),
+	"compare":       compareFn,
+	"sorted-map":    types.Func(sortedMap),
+	"sorted-map-by": types.Func(sortedMapBy),
+	"sorted?":       types.Func(issorted),
+	"subseq":        types.Func(subseq),
+	"rsubseq":       types.Func(rsubseq),
 }
 
 func timems(e types.Env, a []types.Base) (types.Base, error) {
@@ -114,8 +142,9 @@ func seq(e types.Env, a []types.Base) (types.Base, error) {
 		if v == "" {
 			return nil, nil
 		}
-		result := make([]types.Base, len(v))
-		for i, ch := range strings.Split(v, "") {
+		chars := strings.Split(v, "")
+		result := make([]types.Base, len(chars))
+		for i, ch := range chars {
 			result[i] = ch
 		}
 		return types.NewList(result...), nil
@@ -136,6 +165,45 @@ func isstring(e types.Env, a []types.Base) (types.Base, error) {
 	}
 }
 
+func ischar(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	_, ok := a[0].(types.Char)
+	return ok, nil
+}
+
+func makechar(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	switch val := a[0].(type) {
+	case types.Char:
+		return val, nil
+	case float64:
+		if val < 0 || val > unicode.MaxRune || val != math.Trunc(val) {
+			return nil, errors.New("value out of range for char")
+		}
+		return types.Char(val), nil
+	default:
+		return nil, errors.New("char expects a number or a char")
+	}
+}
+
+func toint(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	switch val := a[0].(type) {
+	case types.Char:
+		return float64(val), nil
+	case float64:
+		return math.Trunc(val), nil
+	default:
+		return nil, errors.New("int expects a number or a char")
+	}
+}
+
 func isnumber(e types.Env, a []types.Base) (types.Base, error) {
 	if err := assertArgNum(a, 1); err != nil {
 		return nil, err
@@ -180,57 +248,25 @@ func rdline(e types.Env, a []types.Base) (types.Base, error) {
 			prompt = p
 		}
 	}
-	return readline.Readline(prompt)
+	line, err := readline.Readline(prompt)
+	if err == io.EOF {
+		return nil, nil
+	}
+	return line, err
 }
 
 func meta(e types.Env, a []types.Base) (types.Base, error) {
 	if err := assertArgNum(a, 1); err != nil {
 		return nil, err
 	}
-	switch val := a[0].(type) {
-	case *types.List:
-		return val.Meta, nil
-	case *types.Vector:
-		return val.Meta, nil
-	case *types.Hashmap:
-		return val.Meta, nil
-	case *types.StdFunc:
-		return val.Meta, nil
-	case *types.ExtFunc:
-		return val.Meta, nil
-	default:
-		return nil, nil
-	}
+	return types.GetMeta(a[0]), nil
 }
 
 func withmeta(e types.Env, a []types.Base) (types.Base, error) {
 	if err := assertArgNum(a, 2); err != nil {
 		return nil, err
 	}
-	switch val := a[0].(type) {
-	case *types.List:
-		list := types.NewList(val.Forms...)
-		list.Meta = a[1]
-		return list, nil
-	case *types.Vector:
-		vect := types.NewVect(val.Forms...)
-		vect.Meta = a[1]
-		return vect, nil
-	case *types.Hashmap:
-		hmap, _ := types.NewHashmap(val.ToList())
-		hmap.Meta = a[1]
-		return hmap, nil
-	case *types.StdFunc:
-		clonedFn := types.Func(val.Fn)
-		clonedFn.Meta = a[1]
-		return clonedFn, nil
-	case *types.ExtFunc:
-		clonedFn := val.Clone()
-		clonedFn.Meta = a[1]
-		return clonedFn, nil
-	default:
-		return nil, errors.New("invalid data type for metadata")
-	}
+	return types.WithMeta(a[0], a[1])
 }
 
 func assoc(e types.Env, a []types.Base) (types.Base, error) {
@@ -241,7 +277,11 @@ func assoc(e types.Env, a []types.Base) (types.Base, error) {
 	if !isHmap {
 		return nil, errors.New("cannot assoc with non-hashmap")
 	}
-	return types.NewHashmap(append(hmap.ToList(), a[1:]...))
+	result, err := types.NewHashmap(append(hmap.ToList(), a[1:]...))
+	if err != nil || hmap.Comparator == nil {
+		return result, err
+	}
+	return result, sortKeys(e, result, hmap.Comparator)
 }
 
 func dissoc(e types.Env, a []types.Base) (types.Base, error) {
@@ -252,7 +292,11 @@ func dissoc(e types.Env, a []types.Base) (types.Base, error) {
 	if !isHmap {
 		return nil, errors.New("cannot dissoc with non-hashmap")
 	}
-	return types.NewHashmap(hmap.ToList(), a[1:]...)
+	result, err := types.NewHashmap(hmap.ToList(), a[1:]...)
+	if err != nil || hmap.Comparator == nil {
+		return result, err
+	}
+	return result, sortKeys(e, result, hmap.Comparator)
 }
 
 func get(e types.Env, a []types.Base) (types.Base, error) {
@@ -454,7 +498,7 @@ func nth(e types.Env, a []types.Base) (types.Base, error) {
 		return nil, fmt.Errorf("invalid value to index on collection")
 	}
 	data := col.Data()
-	if len(data) <= int(n) {
+	if n < 0 || len(data) <= int(n) {
 		return nil, fmt.Errorf("index out of bounds")
 	}
 	return data[int(n)], nil
@@ -577,9 +621,14 @@ func readString(e types.Env, a []types.Base) (types.Base, error) {
 }
 
 func slurp(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
 	path, ok := a[0].(string)
 	if !ok {
 		return nil, errors.New("cannot read source from non-string path")
+	} else if err := allowedPath(e, path); err != nil {
+		return nil, ioError("slurp", path, err)
 	}
 	b, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -589,23 +638,97 @@ func slurp(e types.Env, a []types.Base) (types.Base, error) {
 }
 
 func prn(e types.Env, a []types.Base) (types.Base, error) {
-	fmt.Println(printer.List(a, true, "", "", " "))
+	out, err := printArgs(e, a, true, " ")
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println(out)
 	return nil, nil
 }
 
 func prnln(e types.Env, a []types.Base) (types.Base, error) {
-	fmt.Println(printer.List(a, false, "", "", " "))
+	out, err := printArgs(e, a, false, " ")
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println(out)
 	return nil, nil
 }
 
 func prnstr(e types.Env, a []types.Base) (types.Base, error) {
-	return printer.List(a, true, "", "", " "), nil
+	return printArgs(e, a, true, " ")
 }
 
 func str(e types.Env, a []types.Base) (types.Base, error) {
+	if err := chargePrint(e, printer.Options{}, a); err != nil {
+		return nil, err
+	}
 	return printer.List(a, false, "", "", ""), nil
 }
 
+// printArgs prints each of the values within the limits set by *print-length*
+// and *print-level*, joined by sep
+func printArgs(e types.Env, a []types.Base, readably bool, sep string) (string, error) {
+	opts := PrintOptions(e)
+	if err := chargePrint(e, opts, a); err != nil {
+		return "", err
+	}
+	strs := make([]string, len(a))
+	for i, val := range a {
+		strs[i] = opts.Print(val, readably)
+	}
+	return strings.Join(strs, sep), nil
+}
+
+// chargePrint counts the values that printing will visit towards the step
+// limit, if there is one. A collection that holds the same collection many
+// times prints much larger than it is, so only the values within the limits of
+// the options are counted.
+func chargePrint(e types.Env, opts printer.Options, vals []types.Base) error {
+	if !runtime.StepLimited(e) {
+		return nil
+	}
+	return chargeForms(e, opts, vals, nil, 1, 0, map[*types.Atom]bool{})
+}
+
+// chargeForms counts the values, per values to an item, up to limit items or
+// all of them when limit is nil. The values are nested depth collections deep,
+// and the atoms are the ones that they are inside of, which are printed as
+// (atom ...) if they hold themselves.
+func chargeForms(e types.Env, opts printer.Options, vals []types.Base, limit *int, per, depth int, atoms map[*types.Atom]bool) error {
+	nested := opts.Level == nil || depth < *opts.Level
+	for i, val := range vals {
+		if limit != nil && i >= *limit*per {
+			return nil
+		} else if err := runtime.Charge(e, 1); err != nil {
+			return err
+		}
+		var err error
+		switch tval := val.(type) {
+		case types.Collection:
+			if nested {
+				err = chargeForms(e, opts, tval.Data(), opts.Length, 1, depth+1, atoms)
+			}
+		case *types.Hashmap:
+			if nested {
+				err = chargeForms(e, opts, tval.ToList(), opts.Length, 2, depth+1, atoms)
+			}
+		case *types.Atom:
+			if !atoms[tval] {
+				atoms[tval] = true
+				err = chargeForms(e, opts, []types.Base{tval.Val}, nil, 1, depth, atoms)
+				delete(atoms, tval)
+			}
+		case string:
+			err = runtime.Charge(e, int64(len(tval)/64))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func list(e types.Env, a []types.Base) (types.Base, error) {
 	return types.NewList(a...), nil
 }
@@ -644,7 +767,7 @@ func count(e types.Env, a []types.Base) (types.Base, error) {
 	case *types.Hashmap:
 		return float64(len(data.Forms)), nil
 	case string:
-		return float64(len(data)), nil
+		return float64(utf8.RuneCountInString(data)), nil
 	case nil:
 		return float64(0), nil
 	default:
@@ -695,6 +818,22 @@ func checkEquality(val1, val2 types.Base) (bool, error) {
 		return data == other, nil
 	case nil:
 		return true, nil
+	case *env.Namespace:
+		return data == val2, nil
+	case types.Char:
+		return data == val2.(types.Char), nil
+	case *types.Regex:
+		return data.String() == val2.(*types.Regex).String(), nil
+	case *types.Tagged:
+		other := val2.(*types.Tagged)
+		if data.Tag != other.Tag {
+			return false, nil
+		}
+		return checkEquality(data.Form, other.Form)
+	case *types.Matcher:
+		return data == val2, nil
+	case *types.Handle:
+		return data == val2, nil
 	default:
 		return false, errors.New("invalid data type passed to equal")
 	}
@@ -785,31 +924,50 @@ func greaterThanEqual(e types.Env, a []types.Base) (types.Base, error) {
 }
 
 func add(e types.Env, a []types.Base) (types.Base, error) {
-	if e := assertArgNum(a, 2); e != nil {
-		return nil, e
+	x, y, err := numberArgs(a, "add")
+	if err != nil {
+		return nil, err
 	}
-	return a[0].(float64) + a[1].(float64), nil
+	return x + y, nil
 }
 
 func sub(e types.Env, a []types.Base) (types.Base, error) {
-	if e := assertArgNum(a, 2); e != nil {
-		return nil, e
+	x, y, err := numberArgs(a, "subtract")
+	if err != nil {
+		return nil, err
 	}
-	return a[0].(float64) - a[1].(float64), nil
+	return x - y, nil
 }
 
 func mul(e types.Env, a []types.Base) (types.Base, error) {
-	if e := assertArgNum(a, 2); e != nil {
-		return nil, e
+	x, y, err := numberArgs(a, "multiply")
+	if err != nil {
+		return nil, err
 	}
-	return a[0].(float64) * a[1].(float64), nil
+	return x * y, nil
 }
 
 func div(e types.Env, a []types.Base) (types.Base, error) {
-	if e := assertArgNum(a, 2); e != nil {
-		return nil, e
+	x, y, err := numberArgs(a, "divide")
+	if err != nil {
+		return nil, err
+	}
+	return x / y, nil
+}
+
+func numberArgs(a []types.Base, action string) (float64, float64, error) {
+	if err := assertArgNum(a, 2); err != nil {
+		return 0, 0, err
+	}
+	x, ok := a[0].(float64)
+	if !ok {
+		return 0, 0, fmt.Errorf("cannot %v non-number values", action)
+	}
+	y, ok := a[1].(float64)
+	if !ok {
+		return 0, 0, fmt.Errorf("cannot %v non-number values", action)
 	}
-	return a[0].(float64) / a[1].(float64), nil
+	return x, y, nil
 }
 
 func assertArgNum(a []types.Base, expectedLen int) error {
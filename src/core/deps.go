@@ -0,0 +1,200 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/tanema/mal/src/env"
+	"github.com/tanema/mal/src/reader"
+	"github.com/tanema/mal/src/types"
+)
+
+// Dependency is a source file that a program loads
+type Dependency struct {
+	// Path is the absolute path of the file
+	Path string
+	// Dir is the directory that the file was found from. The file has to stay in
+	// the same place relative to it for the program to find it again.
+	Dir string
+	// Search is true when Dir is the working directory or part of *load-path*
+	// rather than the directory of the file or namespace that loads it
+	Search bool
+}
+
+// scanner finds the files that a program loads
+type scanner struct {
+	env  *env.Env
+	seen map[string]bool
+	deps []Dependency
+}
+
+// LibraryPath returns the path that load-file uses for a file in a bundled
+// library, like lib:wot/core.mal
+func LibraryPath(name string) string {
+	return libPrefix + filepath.ToSlash(name)
+}
+
+// Dependencies finds the files that the file at path loads with load-file,
+// require and ns, and the files that those load in turn. The file itself is the
+// first dependency. Only literal paths and quoted namespace names can be found
+// without running the program. Files in bundled libraries and namespaces that
+// are already loaded are left out.
+func Dependencies(e *env.Env, path string) ([]Dependency, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &scanner{env: e, seen: map[string]bool{}}
+	if err := s.scan(Dependency{Path: abs, Dir: filepath.Dir(abs)}); err != nil {
+		return nil, err
+	}
+	return s.deps, nil
+}
+
+func (s *scanner) scan(dep Dependency) error {
+	if s.seen[dep.Path] {
+		return nil
+	}
+	s.seen[dep.Path] = true
+	s.deps = append(s.deps, dep)
+	source, err := readSource(dep.Path)
+	if err != nil {
+		return fmt.Errorf("problem reading source file: %v", err)
+	}
+	forms, err := reader.ReadAll(string(source))
+	if err != nil {
+		return fmt.Errorf("%v: %v", dep.Path, err)
+	}
+	f := &scannedFile{scanner: s, path: dep.Path}
+	for _, form := range forms {
+		if err := f.walk(form); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scannedFile tracks the namespace that a file declares so that the namespaces
+// it requires can be found relative to the namespace root
+type scannedFile struct {
+	*scanner
+	path string
+	ns   types.Symbol
+}
+
+func (f *scannedFile) walk(form types.Base) error {
+	col, ok := form.(types.Collection)
+	if !ok {
+		if hm, isMap := form.(*types.Hashmap); isMap {
+			for _, val := range hm.Forms {
+				if err := f.walk(val); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	data := col.Data()
+	if _, isList := form.(*types.List); isList && len(data) > 0 {
+		switch data[0] {
+		case types.Symbol("quote"), types.Symbol("quasiquote"):
+			return nil
+		case types.Symbol("load-file"):
+			if path, isStr := second(data).(string); isStr {
+				return f.loadFile(path)
+			}
+		case types.Symbol("in-ns"):
+			if name, isSym := unquote(second(data)).(types.Symbol); isSym {
+				f.ns = name
+			}
+		case types.Symbol("require"):
+			return f.requireSpecs(data[1:], true)
+		case types.Symbol("ns"):
+			return f.declareNs(data)
+		}
+	}
+	for _, child := range data {
+		if err := f.walk(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *scannedFile) loadFile(path string) error {
+	base := filepath.Dir(f.path)
+	resolved, dir, err := resolveFrom(f.env, path, base)
+	if err != nil {
+		return fmt.Errorf("%v: %v", f.path, err)
+	} else if isLibPath(resolved) {
+		return nil
+	}
+	return f.scan(Dependency{Path: resolved, Dir: dir, Search: dir != base})
+}
+
+func (f *scannedFile) declareNs(data []types.Base) error {
+	name, ok := second(data).(types.Symbol)
+	if !ok {
+		return nil
+	}
+	f.ns = name
+	for _, clause := range data[2:] {
+		col, ok := clause.(*types.List)
+		if ok && len(col.Forms) > 0 && col.Forms[0] == types.Keyword("require") {
+			if err := f.requireSpecs(col.Forms[1:], false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// requireSpecs finds the namespaces in require specs. Specs given to the require
+// function are quoted while the specs in an ns form are not.
+func (f *scannedFile) requireSpecs(specs []types.Base, quoted bool) error {
+	for _, spec := range specs {
+		if quoted {
+			spec = unquote(spec)
+		}
+		if col, isCol := spec.(types.Collection); isCol && len(col.Data()) > 0 {
+			spec = col.Data()[0]
+		}
+		if name, isSym := spec.(types.Symbol); isSym {
+			if err := f.requireNs(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f *scannedFile) requireNs(name types.Symbol) error {
+	if f.env.Namespace().Registry().Find(name) != nil {
+		return nil
+	}
+	root := filepath.Dir(f.path)
+	if f.ns != "" {
+		root = nsRoot(f.path, f.ns)
+	}
+	resolved, dir, err := resolveFrom(f.env, nsPath(name), root)
+	if err != nil {
+		return fmt.Errorf("%v: could not load namespace %v: %v", f.path, name, err)
+	} else if isLibPath(resolved) {
+		return nil
+	}
+	return f.scan(Dependency{Path: resolved, Dir: dir, Search: dir != root})
+}
+
+func second(data []types.Base) types.Base {
+	if len(data) < 2 {
+		return nil
+	}
+	return data[1]
+}
+
+func unquote(form types.Base) types.Base {
+	if list, ok := form.(*types.List); ok && len(list.Forms) == 2 && list.Forms[0] == types.Symbol("quote") {
+		return list.Forms[1]
+	}
+	return form
+}
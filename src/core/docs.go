@@ -0,0 +1,330 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tanema/mal/src/printer"
+	"github.com/tanema/mal/src/reader"
+	"github.com/tanema/mal/src/types"
+)
+
+// docs holds the arglists and docstrings of the builtin functions. They are kept
+// apart from the metadata of the functions so that (meta +) is still nil.
+var docs = map[types.Symbol][2]string{
+	"+":             {"([x y])", "Returns the sum of x and y."},
+	"-":             {"([x y])", "Returns x minus y."},
+	"*":             {"([x y])", "Returns the product of x and y."},
+	"/":             {"([x y])", "Returns x divided by y."},
+	"=":             {"([x y])", "Returns true if x and y are equal. Lists and vectors with the same\nelements are equal."},
+	"<":             {"([x y])", "Returns true if x is less than y."},
+	"<=":            {"([x y])", "Returns true if x is less than or equal to y."},
+	">":             {"([x y])", "Returns true if x is greater than y."},
+	">=":            {"([x y])", "Returns true if x is greater than or equal to y."},
+	"prn":           {"([& xs])", "Prints the values readably, separated by spaces, followed by a newline.\nCollections are cut short by *print-length* and *print-level*, and\n*print-meta* prints their metadata."},
+	"println":       {"([& xs])", "Prints the values, separated by spaces, followed by a newline, within\n*print-length* and *print-level* like prn."},
+	"pr-str":        {"([& xs])", "Returns the values printed readably like prn and separated by spaces as\na string."},
+	"pprint":        {"([x & opts])", "Prints x readably, breaking collections that do not fit in\n*print-right-margin* columns over several lines. With :code true lists\nare laid out like source code."},
+	"pprint-str":    {"([x & opts])", "Returns x pretty printed like pprint as a string."},
+	"str":           {"([& xs])", "Returns the values printed and concatenated together as a string."},
+	"list":          {"([& items])", "Creates a new list containing the items."},
+	"list?":         {"([x])", "Returns true if x is a list."},
+	"empty?":        {"([coll])", "Returns true if coll has no items or is nil."},
+	"count":         {"([coll])", "Returns the number of items in the collection, or characters in a string."},
+	"read-string":   {"([s])", "Reads the first form from the string s without evaluating it."},
+	"slurp":         {"([path])", "Reads the whole file at path and returns it as a string."},
+	"atom":          {"([x])", "Creates an atom with the initial value x."},
+	"atom?":         {"([x])", "Returns true if x is an atom."},
+	"deref":         {"([atom])", "Returns the current value of the atom. Also available as @atom."},
+	"reset!":        {"([atom newval])", "Sets the value of the atom to newval and returns newval."},
+	"swap!":         {"([atom f & args])", "Sets the value of the atom to (apply f current-value args) and returns\nthe new value."},
+	"cons":          {"([x coll])", "Returns a new list with x as the first item and coll as the rest."},
+	"concat":        {"([& colls])", "Returns a list of all of the items in the collections joined together."},
+	"nth":           {"([coll index])", "Returns the item at index in the collection. Throws an error if the\nindex is out of bounds."},
+	"first":         {"([coll])", "Returns the first item in the collection, or nil if it is empty."},
+	"rest":          {"([coll])", "Returns a list of all the items after the first in the collection."},
+	"throw":         {"([x])", "Throws x as an exception that can be caught with try*/catch*."},
+	"apply":         {"([f & args])", "Calls f with args. If the last argument is a collection its items are\nadded to the arguments."},
+	"map":           {"([f coll])", "Returns a list of the result of calling f on each item in coll."},
+	"nil?":          {"([x])", "Returns true if x is nil."},
+	"true?":         {"([x])", "Returns true if x is the value true."},
+	"false?":        {"([x])", "Returns true if x is the value false."},
+	"symbol?":       {"([x])", "Returns true if x is a symbol."},
+	"symbol":        {"([name])", "Returns a symbol with the given name."},
+	"keyword?":      {"([x])", "Returns true if x is a keyword."},
+	"keyword":       {"([name])", "Returns a keyword with the given name."},
+	"vector?":       {"([x])", "Returns true if x is a vector."},
+	"vector":        {"([& items])", "Creates a new vector containing the items."},
+	"map?":          {"([x])", "Returns true if x is a hash map."},
+	"hash-map":      {"([& keyvals])", "Creates a new hash map from the interleaved keys and values."},
+	"assoc":         {"([map key val & kvs])", "Returns a new map with the keys set to the values."},
+	"dissoc":        {"([map & keys])", "Returns a new map without the keys."},
+	"get":           {"([map key])", "Returns the value mapped to key, or nil if it is not present."},
+	"contains?":     {"([map key])", "Returns true if key is present in the map."},
+	"keys":          {"([map])", "Returns a list of the keys in the map, in the order of compare or the\ncomparator of a sorted map."},
+	"vals":          {"([map])", "Returns a list of the values in the map, in the order of their keys."},
+	"sequential?":   {"([x])", "Returns true if x is a list or a vector."},
+	"readline":      {"([prompt])", "Reads a line of input from the user after showing the prompt. Returns\nnil at the end of input."},
+	"meta":          {"([x])", "Returns the metadata of x, or nil if there is none."},
+	"with-meta":     {"([x meta])", "Returns a copy of x with meta as its metadata."},
+	"string?":       {"([x])", "Returns true if x is a string."},
+	"number?":       {"([x])", "Returns true if x is a number."},
+	"char?":         {"([x])", "Returns true if x is a character."},
+	"char":          {"([x])", "Returns the character with the code point x. Characters are written\n\\a, \\newline or \\u00e9."},
+	"int":           {"([x])", "Returns the code point of the character x, or the number x rounded\ntowards zero."},
+	"fn?":           {"([x])", "Returns true if x is a function, but not a macro."},
+	"macro?":        {"([x])", "Returns true if x is a macro."},
+	"conj":          {"([coll & xs])", "Returns a new collection with the xs added. Items are added to the front\nof lists and the end of vectors."},
+	"seq":           {"([coll])", "Returns a list of the items in coll, or the characters in a string.\nReturns nil if it is empty."},
+	"time-ms":       {"([])", "Returns the current time in milliseconds."},
+	"print-doc":     {"([sym])", "Prints the arglists and docstring of the value named by sym."},
+	"find-doc":      {"([pattern])", "Prints the documentation of every definition whose name or docstring\nmatches the regular expression pattern."},
+	"apropos":       {"([pattern])", "Returns a sorted list of the symbols whose name contains the pattern."},
+	"source-fn":     {"([sym])", "Returns the source of the function named by sym as a string, or nil if\nit is not available."},
+	"re-pattern":    {"([s])", "Compiles the string s into a regex. Regex literals are written\n#\"pattern\" and their backslashes are not escapes."},
+	"re-matcher":    {"([re s])", "Returns a matcher that steps through the matches of re in s with\nre-find."},
+	"re-find":       {"([m] [re s])", "Returns the next match of the matcher m, or the first match of re in s.\nA match is the matched string if re has no groups, otherwise a vector\nof the match followed by each group. Returns nil if there is no match."},
+	"re-matches":    {"([re s])", "Returns the match of re if it matches the whole of s, otherwise nil."},
+	"re-seq":        {"([re s])", "Returns a list of every match of re in s, or nil if there are none."},
+	"re-groups":     {"([m])", "Returns the last match that re-find found with the matcher m."},
+	"regex?":        {"([x])", "Returns true if x is a regex."},
+	"compare":       {"([x y])", "Returns -1, 0 or 1 if x comes before, with or after y. Values of\ndifferent types are ordered by type, with nil first, then booleans,\nnumbers, characters, strings, symbols and keywords."},
+	"sorted-map":    {"([& keyvals])", "Creates a map that keeps its keys in the order of compare."},
+	"sorted-map-by": {"([comparator & keyvals])", "Creates a map that keeps its keys in the order of the comparator, which\nreturns a number like compare or true if its first argument comes first,\nlike <."},
+	"sorted?":       {"([x])", "Returns true if x is a sorted map."},
+	"subseq":        {"([sm test key] [sm start-test start-key end-test end-key])", "Returns a list of the [key value] entries of the sorted map whose keys\npass the tests, like (subseq sm >= 2 < 5), in order."},
+	"rsubseq":       {"([sm test key] [sm start-test start-key end-test end-key])", "Like subseq, but returns the entries in reverse order."},
+}
+
+// specialForms describes the forms handled by the evaluator for print-doc
+var specialForms = map[types.Symbol][2]string{
+	"def!":           {"(def! name doc? value)", "Evaluates value and binds it to name in the current environment. A\ndocstring can be given before the value."},
+	"defmacro!":      {"(defmacro! name doc? fn)", "Binds the function to name as a macro."},
+	"let*":           {"(let* [name value ...] body)", "Evaluates body with the names bound to the values."},
+	"fn*":            {"(fn* [params*] body)", "Creates a function. A & in the params binds the remaining arguments\nto the following name."},
+	"do":             {"(do exprs*)", "Evaluates each expression in order and returns the last value."},
+	"if":             {"(if test then else?)", "Evaluates then if test is not nil or false, otherwise evaluates else."},
+	"quote":          {"(quote form)", "Returns form without evaluating it. Also available as 'form."},
+	"quasiquote":     {"(quasiquote form)", "Returns form without evaluating it, except for unquoted parts. Also\navailable as `form."},
+	"unquote":        {"(unquote form)", "Evaluates form inside of a quasiquote. Also available as ~form."},
+	"splice-unquote": {"(splice-unquote form)", "Evaluates form inside of a quasiquote and splices the items into the\nsurrounding list. Also available as ~@form."},
+	"macroexpand":    {"(macroexpand form)", "Returns the expansion of the macro call form."},
+	"try*":           {"(try* expr (catch* name handler))", "Evaluates expr. If an exception is thrown it is bound to name and\nhandler is evaluated."},
+	"catch*":         {"(catch* name handler)", "Handles an exception inside of try*."},
+}
+
+func init() {
+	documentAll(namespace, docs)
+}
+
+// documentAll adds the arglists and docstrings to the functions with the same
+// name
+func documentAll(fns map[types.Symbol]*types.StdFunc, fnDocs map[types.Symbol][2]string) {
+	for name, doc := range fnDocs {
+		if fn, ok := fns[name]; ok {
+			documentBuiltin(fn, doc[0], doc[1])
+		}
+	}
+}
+
+// documentBuiltin sets the docs of the Go function to its arglists and
+// docstring
+func documentBuiltin(fn *types.StdFunc, arglists, doc string) {
+	args, _ := reader.ReadString(arglists)
+	fn.Doc, _ = types.NewHashmap([]types.Base{
+		types.Keyword("arglists"), args,
+		types.Keyword("doc"), doc,
+	})
+}
+
+// docMeta finds the metadata that documents a value, falling back to the docs
+// of a builtin function
+func docMeta(val types.Base) *types.Hashmap {
+	if hm, isMap := types.GetMeta(val).(*types.Hashmap); isMap {
+		if _, hasDoc := hm.Forms[types.Keyword("doc")]; hasDoc {
+			return hm
+		}
+	}
+	if fn, isStd := val.(*types.StdFunc); isStd && fn.Doc != nil {
+		return fn.Doc
+	}
+	hm, _ := types.GetMeta(val).(*types.Hashmap)
+	return hm
+}
+
+// Describe formats the name, arglists and docstring of a value from its
+// metadata. If there is no arglist in the metadata the params of the function
+// are used.
+func Describe(name string, val types.Base) string {
+	var params []types.Base
+	kind := ""
+	switch fn := val.(type) {
+	case *types.StdFunc:
+	case *types.ExtFunc:
+		params = fn.Params
+		if fn.IsMacro {
+			kind = "Macro"
+		}
+	default:
+		if _, isDocumented := docString(val); !isDocumented {
+			return name + "\n  " + printer.Print(val, true)
+		}
+	}
+	lines := []string{"-------------------------", name}
+	hm := docMeta(val)
+	if hm != nil && hm.Forms[types.Keyword("arglists")] != nil {
+		lines = append(lines, printer.Print(hm.Forms[types.Keyword("arglists")], true))
+	} else if params != nil {
+		lines = append(lines, "("+printer.List(params, true, "[", "]", " ")+")")
+	}
+	if kind != "" {
+		lines = append(lines, kind)
+	}
+	if doc, ok := docString(val); ok {
+		for _, line := range strings.Split(doc, "\n") {
+			lines = append(lines, "  "+strings.TrimSpace(line))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// DescribeSpecialForm formats the syntax and docs of a special form, it returns
+// false if the symbol is not a special form
+func DescribeSpecialForm(name types.Symbol) (string, bool) {
+	form, ok := specialForms[name]
+	if !ok {
+		return "", false
+	}
+	lines := []string{"-------------------------", string(name), form[0], "Special Form"}
+	for _, line := range strings.Split(form[1], "\n") {
+		lines = append(lines, "  "+line)
+	}
+	return strings.Join(lines, "\n"), true
+}
+
+func docString(val types.Base) (string, bool) {
+	hm := docMeta(val)
+	if hm == nil {
+		return "", false
+	}
+	doc, isString := hm.Forms[types.Keyword("doc")].(string)
+	return doc, isString
+}
+
+func printdoc(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	name, ok := a[0].(types.Symbol)
+	if !ok {
+		return nil, errors.New("print-doc expects a symbol")
+	}
+	if desc, isSpecial := DescribeSpecialForm(name); isSpecial {
+		fmt.Println(desc)
+		return nil, nil
+	}
+	val, err := e.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println(Describe(string(name), val))
+	return nil, nil
+}
+
+func finddoc(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	pattern, ok := a[0].(string)
+	if !ok {
+		return nil, errors.New("find-doc expects a string pattern")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range sortedSymbols(e.Symbols()) {
+		val, _ := e.Get(name)
+		doc, _ := docString(val)
+		if re.MatchString(string(name)) || re.MatchString(doc) {
+			fmt.Println(Describe(string(name), val))
+		}
+	}
+	for _, name := range sortedSymbols(keysOf(specialForms)) {
+		if re.MatchString(string(name)) || re.MatchString(specialForms[name][1]) {
+			desc, _ := DescribeSpecialForm(name)
+			fmt.Println(desc)
+		}
+	}
+	return nil, nil
+}
+
+func apropos(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	pattern, ok := a[0].(string)
+	if !ok {
+		return nil, errors.New("apropos expects a string pattern")
+	}
+	matches := []types.Base{}
+	for _, name := range sortedSymbols(append(e.Symbols(), keysOf(specialForms)...)) {
+		if strings.Contains(string(name), pattern) {
+			matches = append(matches, name)
+		}
+	}
+	return types.NewList(matches...), nil
+}
+
+// sourcefn rebuilds the definition of a function from its params and body since
+// the original text is not kept around
+func sourcefn(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	name, ok := a[0].(types.Symbol)
+	if !ok {
+		return nil, errors.New("source-fn expects a symbol")
+	}
+	val, err := e.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := val.(*types.ExtFunc)
+	if !ok {
+		return nil, nil
+	}
+	def := []types.Base{types.Symbol("def!"), name}
+	if fn.IsMacro {
+		def[0] = types.Symbol("defmacro!")
+	}
+	if doc, hasDoc := docString(fn); hasDoc {
+		def = append(def, doc)
+	}
+	def = append(def, types.NewList(types.Symbol("fn*"), types.NewList(fn.Params...), fn.AST))
+	return printer.Print(types.NewList(def...), true), nil
+}
+
+func keysOf(m map[types.Symbol][2]string) []types.Symbol {
+	keys := make([]types.Symbol, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func sortedSymbols(symbols []types.Symbol) []types.Symbol {
+	seen := map[types.Symbol]bool{}
+	unique := []types.Symbol{}
+	for _, sym := range symbols {
+		if !seen[sym] {
+			unique = append(unique, sym)
+			seen[sym] = true
+		}
+	}
+	sort.Slice(unique, func(i, j int) bool { return unique[i] < unique[j] })
+	return unique
+}
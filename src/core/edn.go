@@ -0,0 +1,228 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tanema/mal/src/printer"
+	"github.com/tanema/mal/src/reader"
+	"github.com/tanema/mal/src/types"
+)
+
+// EDNNs is the namespace of the EDN functions, like edn/read-string
+const EDNNs = "edn"
+
+// instLayouts are the layouts that #inst timestamps can be written in, from
+// the most to the least precise
+var instLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02", "2006-01", "2006"}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// ednTags are the readers for the tagged literals that every EDN read
+// understands, like #inst and #uuid
+var ednTags = map[types.Symbol]func(types.Base) (types.Base, error){
+	"inst": readInst,
+	"uuid": readUUID,
+}
+
+// RegisterEDNTag adds a reader for a tagged literal to every EDN read. The
+// reader is given the form after the tag and returns the value it stands for.
+func RegisterEDNTag(tag types.Symbol, fn func(types.Base) (types.Base, error)) {
+	ednTags[tag] = fn
+}
+
+var ednNamespace = map[types.Symbol]*types.StdFunc{
+	"read-string":     types.Func(ednReadString),
+	"write-string":    types.Func(ednWriteString),
+	"tagged-literal":  types.Func(taggedLiteral),
+	"tagged-literal?": types.Func(istaggedLiteral),
+	"tag":             types.Func(taggedTag),
+	"form":            types.Func(taggedForm),
+}
+
+var ednDocs = map[types.Symbol][2]string{
+	"read-string":     {"([s & opts] [opts s])", "Reads the first form in the EDN string s without evaluating anything.\nCode like quotes and regexes cannot be read. #inst and #uuid literals are\nread as tagged literals and :readers is a map of tags to functions for\nothers, like {'point make-point}. :default is called with the tag and the\nform of any other tag, and :eof-value, or :eof, is returned if s has no\nforms. The options can also be given as a map before s like in Clojure."},
+	"write-string":    {"([x])", "Returns x written as EDN, with the keys of maps in the order of compare\nso that equal values are always written the same way."},
+	"tagged-literal":  {"([tag form])", "Returns a tagged literal, like the ones read from #inst \"...\"."},
+	"tagged-literal?": {"([x])", "Returns true if x is a tagged literal."},
+	"tag":             {"([x])", "Returns the tag of the tagged literal as a symbol."},
+	"form":            {"([x])", "Returns the form after the tag of the tagged literal."},
+}
+
+func init() {
+	documentAll(ednNamespace, ednDocs)
+}
+
+// readInst reads #inst "2024-10-18T12:00:00Z", keeping the time in UTC
+func readInst(form types.Base) (types.Base, error) {
+	str, ok := form.(string)
+	if !ok {
+		return nil, errors.New("#inst expects a string")
+	}
+	for _, layout := range instLayouts {
+		if t, err := time.Parse(layout, str); err == nil {
+			return &types.Tagged{Tag: "inst", Form: t.UTC().Format(time.RFC3339Nano)}, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid #inst %v", printer.Print(str, true))
+}
+
+// readUUID reads #uuid "f81d4fae-7dec-11d0-a765-00a0c91e6bf6" in lower case
+func readUUID(form types.Base) (types.Base, error) {
+	str, ok := form.(string)
+	if !ok {
+		return nil, errors.New("#uuid expects a string")
+	} else if !uuidPattern.MatchString(str) {
+		return nil, fmt.Errorf("invalid #uuid %v", printer.Print(str, true))
+	}
+	return &types.Tagged{Tag: "uuid", Form: strings.ToLower(str)}, nil
+}
+
+func ednReadString(e types.Env, a []types.Base) (types.Base, error) {
+	if len(a) < 1 {
+		return nil, errors.New("wrong number of arguments")
+	}
+	// the options can also be a map before the string like in Clojure
+	opts := a[1:]
+	if optsMap, isMap := a[0].(*types.Hashmap); isMap {
+		if len(a) != 2 {
+			return nil, errors.New("read-string expects a string after the options map")
+		}
+		opts, a = optsMap.ToList(), a[1:]
+	}
+	src, err := stringArg(a, 0)
+	if err != nil {
+		return nil, err
+	}
+	values, err := keywordOpts(opts, "readers", "default", "eof", "eof-value")
+	if err != nil {
+		return nil, err
+	}
+	eof, hasEOF := values["eof"]
+	if !hasEOF {
+		eof = values["eof-value"]
+	}
+	readers, ok := values["readers"].(*types.Hashmap)
+	if !ok && values["readers"] != nil {
+		return nil, errors.New("read-string expects :readers to be a map")
+	}
+	form, err := reader.ReadEDN(src, func(tag types.Symbol, form types.Base) (types.Base, error) {
+		if readers != nil {
+			if fn, ok := readers.Forms[tag]; ok {
+				return types.CallFunc(e, fn, []types.Base{form})
+			}
+		}
+		if fn, ok := ednTags[tag]; ok {
+			return fn(form)
+		} else if fn := values["default"]; fn != nil {
+			return types.CallFunc(e, fn, []types.Base{tag, form})
+		}
+		return nil, fmt.Errorf("no reader for the tag #%v", tag)
+	})
+	if err == io.EOF {
+		return eof, nil
+	}
+	return form, err
+}
+
+// writeEDN writes the value as EDN, with the keys of maps in the order of
+// compare
+func writeEDN(out *strings.Builder, val types.Base) error {
+	switch tval := val.(type) {
+	case float64:
+		if math.IsInf(tval, 0) || math.IsNaN(tval) {
+			return fmt.Errorf("cannot write %v as EDN", tval)
+		}
+		out.WriteString(printer.Print(tval, true))
+	case nil, bool, string, types.Keyword, types.Symbol, types.Char:
+		out.WriteString(printer.Print(tval, true))
+	case *types.List:
+		return writeEDNForms(out, "(", tval.Forms, ")")
+	case *types.Vector:
+		return writeEDNForms(out, "[", tval.Forms, "]")
+	case *types.Hashmap:
+		return writeEDNForms(out, "{", tval.ToList(), "}")
+	case *types.Tagged:
+		out.WriteString("#" + string(tval.Tag) + " ")
+		return writeEDN(out, tval.Form)
+	default:
+		return fmt.Errorf("cannot write %v as EDN", printer.Print(tval, true))
+	}
+	return nil
+}
+
+func writeEDNForms(out *strings.Builder, start string, forms []types.Base, end string) error {
+	out.WriteString(start)
+	for i, form := range forms {
+		if i > 0 {
+			out.WriteByte(' ')
+		}
+		if err := writeEDN(out, form); err != nil {
+			return err
+		}
+	}
+	out.WriteString(end)
+	return nil
+}
+
+func ednWriteString(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	var out strings.Builder
+	if err := writeEDN(&out, a[0]); err != nil {
+		return nil, err
+	}
+	return out.String(), nil
+}
+
+func taggedLiteral(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 2); err != nil {
+		return nil, err
+	}
+	tag, ok := a[0].(types.Symbol)
+	if !ok {
+		return nil, errors.New("tagged-literal expects a symbol tag")
+	}
+	return &types.Tagged{Tag: tag, Form: a[1]}, nil
+}
+
+func istaggedLiteral(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	_, ok := a[0].(*types.Tagged)
+	return ok, nil
+}
+
+func taggedTag(e types.Env, a []types.Base) (types.Base, error) {
+	tagged, err := taggedArg(a, "tag")
+	if err != nil {
+		return nil, err
+	}
+	return tagged.Tag, nil
+}
+
+func taggedForm(e types.Env, a []types.Base) (types.Base, error) {
+	tagged, err := taggedArg(a, "form")
+	if err != nil {
+		return nil, err
+	}
+	return tagged.Form, nil
+}
+
+func taggedArg(a []types.Base, name string) (*types.Tagged, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	tagged, ok := a[0].(*types.Tagged)
+	if !ok {
+		return nil, fmt.Errorf("%v expects a tagged literal", name)
+	}
+	return tagged, nil
+}
@@ -0,0 +1,42 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tanema/mal/src/printer"
+)
+
+// TestEDN checks the values that edn/read-string and edn/write-string return
+func TestEDN(t *testing.T) {
+	defaultEnv := DefaultNamespace()
+	cases := []struct {
+		src      string
+		expected string
+	}{
+		{`(edn/read-string "{:a [1 2.5 \"s\" nil true]}")`, `{:a [1 2.5 "s" nil true]}`},
+		{`(edn/read-string "[1 #_2 3]")`, `[1 3]`},
+		{`(edn/read-string "#inst \"2024-10-18T00:00:00Z\"")`, `#inst "2024-10-18T00:00:00Z"`},
+		{`(edn/read-string "#uuid \"f81d4fae-7dec-11d0-a765-00a0c91e6bf6\"")`, `#uuid "f81d4fae-7dec-11d0-a765-00a0c91e6bf6"`},
+		{`(edn/read-string "#point [1 2]" :readers {'point (fn* [v] {:x (first v) :y (nth v 1)})})`, `{:x 1 :y 2}`},
+		{`(edn/read-string {:readers {'point (fn* [v] {:x (first v) :y (nth v 1)})}} "#point [1 2]")`, `{:x 1 :y 2}`},
+		{`(edn/read-string {:eof :done} "")`, `:done`},
+		{`(edn/write-string {:b 2 :a 1 :c [1 "x" nil]})`, `"{:a 1 :b 2 :c [1 \"x\" nil]}"`},
+		{`(edn/write-string (edn/read-string "#inst \"2024-10-18T00:00:00Z\""))`, `"#inst \"2024-10-18T00:00:00Z\""`},
+	}
+	for _, c := range cases {
+		if val, err := evalPrint(defaultEnv, c.src); err != nil || val != c.expected {
+			t.Errorf("%v returned %v, %v, expected %v", c.src, val, err, c.expected)
+		}
+	}
+	errs := map[string]string{
+		`(edn/read-string "'a")`:         "' is not allowed in EDN",
+		`(edn/read-string "#unknown 1")`: "no reader for the tag #unknown",
+		`(edn/read-string {} "1" "2")`:   "read-string expects a string after the options map",
+	}
+	for src, expected := range errs {
+		if _, err := evalPrint(defaultEnv, src); err == nil || !strings.Contains(printer.Print(err, true), expected) {
+			t.Errorf("%v returned %v, expected an error with %q", src, err, expected)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package core
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/tanema/mal/src/env"
+	"github.com/tanema/mal/src/printer"
+	"github.com/tanema/mal/src/reader"
+	"github.com/tanema/mal/src/runtime"
+	"github.com/tanema/mal/src/types"
+)
+
+// fuzzStepLimit is the number of steps that each fuzzed program can take
+const fuzzStepLimit = 20000
+
+// sandboxed are the functions that read files or the terminal which fuzzed
+// programs cannot call
+var sandboxed = []types.Symbol{"slurp", "load-file", "readline"}
+
+// emptyStdin replaces *in* with an empty reader so that nothing waits on the
+// terminal
+func emptyStdin(e *env.Env) {
+	e.Set("*in*", types.NewReadHandle("*in*", ioutil.NopCloser(strings.NewReader(""))))
+}
+
+// evalPrint evaluates the forms in src one after another and returns the last
+// value printed readably
+func evalPrint(e *env.Env, src string) (string, error) {
+	forms, err := reader.ReadAll(src)
+	if err != nil {
+		return "", err
+	}
+	var val types.Base
+	for _, form := range forms {
+		if val, err = runtime.Eval(e.Current(), form); err != nil {
+			return "", err
+		}
+	}
+	return printer.Print(val, true), nil
+}
+
+// TestBuiltinArguments calls every builtin in every namespace with combinations
+// of up to three arguments of every kind to check that bad arguments are errors
+// and not panics. Files can only be used in a temporary directory.
+func TestBuiltinArguments(t *testing.T) {
+	defaultEnv := BuiltinNamespace()
+	emptyStdin(defaultEnv)
+	hm, _ := types.NewHashmap([]types.Base{types.Keyword("a"), 1.0})
+	values := []types.Base{
+		nil, true, 1.0, -1.0, "s", types.Symbol("s"), types.Keyword("k"),
+		types.NewList(), types.NewList(1.0, 2.0), types.NewVect("a"), hm,
+		&types.Atom{Val: 1.0}, namespace["+"],
+	}
+	args := [][]types.Base{{}}
+	for _, a := range values {
+		args = append(args, []types.Base{a})
+		for _, b := range values {
+			args = append(args, []types.Base{a, b})
+			for _, c := range values {
+				args = append(args, []types.Base{a, b, c})
+			}
+		}
+	}
+	if err := SetAllowedPaths(defaultEnv, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	stdout := os.Stdout
+	os.Stdout = devNull
+	defer func() { os.Stdout = stdout }()
+	for _, ns := range defaultEnv.Namespace().Registry().All() {
+		for name, val := range ns.Env().Definitions() {
+			fn, ok := val.(*types.StdFunc)
+			if !ok || isSandboxed(name) {
+				continue
+			}
+			for _, a := range args {
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							t.Errorf("(%v/%v %v) panicked: %v", ns.Name, name, printer.List(a, true, "", "", " "), r)
+						}
+					}()
+					fn.Fn(defaultEnv, a)
+				}()
+			}
+		}
+	}
+}
+
+func isSandboxed(name types.Symbol) bool {
+	for _, sym := range sandboxed {
+		if sym == name {
+			return true
+		}
+	}
+	return false
+}
+
+func FuzzEval(f *testing.F) {
+	for _, seed := range []string{
+		`(+ 1 2)`,
+		`(+ "a" 1)`,
+		`((fn* [a b] a) 1)`,
+		`((fn* [& xs] xs))`,
+		`(def! f (fn* [n] (if (= n 0) 0 (f (- n 1))))) (f 10)`,
+		`(def! loop (fn* [] (loop))) (loop)`,
+		`(def! grow (fn* [s] (grow (str s s)))) (grow "a")`,
+		`(def! grow (fn* [xs] (grow (concat xs xs)))) (grow [1])`,
+		`(do) (quasiquote) (macroexpand) (try* 1 (catch*)) (fn* [&]) ((fn* [a & b] b) 1)`,
+		`(let* [a (atom 0)] (swap! a inc))`,
+		"(try* (throw {:a 1}) (catch* e (get e :a)))",
+		"(defmacro! m (fn* [x] `(list ~x ~@[1 2]))) (m 3)",
+		`(map (fn* [x] (* x x)) [1 2 3])`,
+		`(nth [1 2] 5) (first nil) (rest "ab") (count {:a 1})`,
+		`(apply str 1 [2 3]) (concat [1] '(2))`,
+		`(ns my.ns) (defn- p [] 1) (in-ns 'user) (my.ns/p)`,
+		`(require '[wot.perf :as perf]) (perf/time 1)`,
+		`(reduce + 0 [1 2 3]) (-> 1 inc (- 2))`,
+		`(io/spit "f" 1) (io/delete-file "/") (with-open [r (io/reader "f")] (io/read-line r))`,
+		`(json/write-str (json/read-str "{\"a\": [1, null, true]}" :key-fn keyword) :pretty true) (json/read)`,
+		`(subseq (assoc (sorted-map-by > 1 :a 2 :b) 3 :c) >= 2) (rsubseq (sorted-map 1 2) < 5 > 0) (compare [1] "a")`,
+		`(def! *print-right-margin* 5) (pprint-str {:a [1 2 (let* [x 1] x)]} :code true)`,
+		`(def! *print-length* 1) (def! *print-level* 1) (def! *print-meta* true) (let* [a (atom 1)] (do (reset! a [a ^{:m 1} [a]]) (pr-str a (str a) (pprint-str a))))`,
+		`(edn/write-string (edn/read-string "{:a #inst \"2024-10-18\" #_b c [#uuid \"F81D4FAE-7DEC-11D0-A765-00A0C91E6BF6\"]}"))`,
+	} {
+		f.Add(seed)
+	}
+	tempDir := f.TempDir()
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		f.Fatal(err)
+	}
+	defer devNull.Close()
+	f.Fuzz(func(t *testing.T, src string) {
+		forms, err := reader.ReadAll(src)
+		if err != nil {
+			return
+		}
+		defaultEnv := DefaultNamespace()
+		emptyStdin(defaultEnv)
+		if err := SetAllowedPaths(defaultEnv, tempDir); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range sandboxed {
+			defaultEnv.Set(name, types.Func(func(e types.Env, a []types.Base) (types.Base, error) {
+				return nil, errors.New("not available while fuzzing")
+			}))
+		}
+		stdout := os.Stdout
+		os.Stdout = devNull
+		defer func() { os.Stdout = stdout }()
+		runtime.SetStepLimit(defaultEnv, fuzzStepLimit)
+		for _, form := range forms {
+			if _, err := runtime.Eval(defaultEnv.Current(), form); err == runtime.ErrStepLimit {
+				return
+			}
+		}
+	})
+}
+
+// TestStepLimit checks that each environment counts its steps against a limit of
+// its own
+func TestStepLimit(t *testing.T) {
+	limited, other := DefaultNamespace(), DefaultNamespace()
+	runtime.SetStepLimit(limited, 1000)
+	loop := "(def! f (fn* [n] (if (= n 0) n (f (- n 1))))) (f 1000)"
+	if _, err := evalPrint(limited, loop); err != runtime.ErrStepLimit {
+		t.Errorf("the limited environment returned %v, expected %v", err, runtime.ErrStepLimit)
+	}
+	if val, err := evalPrint(other, loop); err != nil || val != "0" {
+		t.Errorf("the other environment returned %v, %v", val, err)
+	}
+	runtime.SetStepLimit(other, 100000)
+	if _, err := evalPrint(limited, "(f 10)"); err != runtime.ErrStepLimit {
+		t.Errorf("setting the limit of one environment reset the count of another")
+	}
+	if val, err := evalPrint(other, loop); err != nil || val != "0" {
+		t.Errorf("the other environment returned %v, %v within its own limit", val, err)
+	}
+}
+
+// TestBuiltinDocs checks that every Go function is documented without being
+// given metadata, including the ones that are created for each environment
+func TestBuiltinDocs(t *testing.T) {
+	for _, ns := range BuiltinNamespace().Namespace().Registry().All() {
+		for name, val := range ns.Env().Definitions() {
+			if fn, ok := val.(*types.StdFunc); ok {
+				if _, hasDoc := docString(fn); !hasDoc || fn.Meta != nil {
+					t.Errorf("%v/%v has docs %v and meta %v", ns.Name, name, printer.Print(fn.Doc, true), printer.Print(fn.Meta, true))
+				}
+			}
+		}
+	}
+}
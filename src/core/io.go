@@ -0,0 +1,479 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tanema/mal/src/env"
+	"github.com/tanema/mal/src/printer"
+	"github.com/tanema/mal/src/types"
+)
+
+// IONs is the namespace of the file system functions, like io/spit
+const IONs = "io"
+
+// ErrNotAllowed is the cause of the errors of file functions that are given a
+// path outside of the paths set with SetAllowedPaths
+var ErrNotAllowed = errors.New("path is not allowed")
+
+// SetAllowedPaths limits the files that slurp, load-file, require and the io
+// functions of the environment's interpreter can use to the ones inside of the
+// directories. With no directories every file can be used, which is the
+// default. It should be called before any code is evaluated.
+func SetAllowedPaths(e *env.Env, dirs ...string) error {
+	var allowed []string
+	for _, dir := range dirs {
+		real, err := resolvePathLinks(dir)
+		if err != nil {
+			return err
+		}
+		allowed = append(allowed, real)
+	}
+	e.Namespace().Registry().AllowedPaths = allowed
+	return nil
+}
+
+var ioNamespace = map[types.Symbol]*types.StdFunc{
+	"spit":         types.Func(spit),
+	"reader":       types.Func(openReader),
+	"writer":       types.Func(openWriter),
+	"read-line":    types.Func(readLine),
+	"write":        types.Func(write),
+	"close":        types.Func(closeHandle),
+	"file-exists?": types.Func(fileExists),
+	"list-dir":     types.Func(listDir),
+	"mkdirs":       types.Func(mkdirs),
+	"delete-file":  types.Func(deleteFile),
+	"file-info":    types.Func(fileInfo),
+	"temp-file":    types.Func(tempFile),
+	"temp-dir":     types.Func(tempDir),
+	"join-path":    types.Func(joinPath),
+}
+
+var ioDocs = map[types.Symbol][2]string{
+	"spit":         {"([path content & opts])", "Writes content, printed like str, to the file at path. With :append true\nit is added to the end of the file instead of replacing it."},
+	"reader":       {"([path])", "Opens the file at path for reading a line at a time with read-line."},
+	"writer":       {"([path & opts])", "Opens the file at path for writing with write. With :append true it is\nadded to instead of replaced."},
+	"read-line":    {"([rdr])", "Reads the next line from the reader without its line ending, or returns\nnil at the end of the file."},
+	"write":        {"([wtr & xs])", "Writes the values, printed like str, to the writer."},
+	"close":        {"([handle])", "Closes a reader or writer, writing anything that is left to the file."},
+	"file-exists?": {"([path])", "Returns true if a file or directory exists at path."},
+	"list-dir":     {"([path])", "Returns a sorted vector of the names of the files in the directory."},
+	"mkdirs":       {"([path])", "Creates the directory at path along with any parents that are missing."},
+	"delete-file":  {"([path])", "Deletes the file or empty directory at path."},
+	"file-info":    {"([path])", "Returns a map of the :name, :path, :size, :directory?, :modified time in\nmilliseconds and :mode of the file at path."},
+	"temp-file":    {"([] [prefix suffix])", "Creates a new empty file in the temporary directory and returns its path."},
+	"temp-dir":     {"([] [prefix])", "Creates a new directory in the temporary directory and returns its path."},
+	"join-path":    {"([& parts])", "Joins the parts into a path with the separator of the system."},
+}
+
+func init() {
+	documentAll(ioNamespace, ioDocs)
+}
+
+// ioError creates the error that is thrown when a file function fails. It is a
+// map with :type :io-error, the :op that failed, the :path, a :reason keyword
+// like :not-found, :exists, :permission, :closed or :not-allowed and the
+// :message.
+func ioError(op, path string, err error) error {
+	reason := "error"
+	switch {
+	case errors.Is(err, ErrNotAllowed):
+		reason = "not-allowed"
+	case errors.Is(err, fs.ErrNotExist):
+		reason = "not-found"
+	case errors.Is(err, fs.ErrExist):
+		reason = "exists"
+	case errors.Is(err, fs.ErrPermission):
+		reason = "permission"
+	case errors.Is(err, fs.ErrClosed):
+		reason = "closed"
+	}
+	hm, _ := types.NewHashmap([]types.Base{
+		types.Keyword("type"), types.Keyword("io-error"),
+		types.Keyword("op"), op,
+		types.Keyword("path"), path,
+		types.Keyword("reason"), types.Keyword(reason),
+		types.Keyword("message"), err.Error(),
+	})
+	return types.UserError{Val: hm}
+}
+
+// allowedPath checks that the path is inside of one of the allowed paths of
+// the environment's interpreter
+func allowedPath(e types.Env, path string) error {
+	var allowedPaths []string
+	if tenv, ok := e.(*env.Env); ok && tenv.Namespace() != nil {
+		allowedPaths = tenv.Namespace().Registry().AllowedPaths
+	}
+	if len(allowedPaths) == 0 {
+		return nil
+	}
+	real, err := resolvePathLinks(path)
+	if err != nil {
+		return err
+	}
+	for _, dir := range allowedPaths {
+		rel, err := filepath.Rel(dir, real)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil
+		}
+	}
+	return ErrNotAllowed
+}
+
+// resolvePathLinks makes the path absolute and follows the symbolic links in
+// the part of it that exists so that a link cannot lead out of an allowed path
+func resolvePathLinks(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	dir, rest := abs, ""
+	for {
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(real, rest), nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return abs, nil
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+		dir = parent
+	}
+}
+
+// pathArg returns the path argument after checking that it is allowed
+func pathArg(e types.Env, a []types.Base, i int, op string) (string, error) {
+	path, err := stringArg(a, i)
+	if err != nil {
+		return "", err
+	} else if err := allowedPath(e, path); err != nil {
+		return "", ioError(op, path, err)
+	}
+	return path, nil
+}
+
+// keywordOpts reads the keyword options given after the required arguments of
+// a function, like :append true. Only the names given are allowed.
+func keywordOpts(opts []types.Base, names ...types.Keyword) (map[types.Keyword]types.Base, error) {
+	if len(opts)%2 == 1 {
+		return nil, errors.New("odd number of options")
+	}
+	values := map[types.Keyword]types.Base{}
+	for i := 0; i < len(opts); i += 2 {
+		name, ok := opts[i].(types.Keyword)
+		if !ok || !hasKeyword(names, name) {
+			return nil, fmt.Errorf("unknown option %v", printer.Print(opts[i], true))
+		}
+		values[name] = opts[i+1]
+	}
+	return values, nil
+}
+
+func hasKeyword(names []types.Keyword, name types.Keyword) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// truthy checks if a value counts as true in a condition
+func truthy(val types.Base) bool {
+	return val != nil && val != false
+}
+
+// appendOpt reads the :append option given after the required arguments
+func appendOpt(opts []types.Base) (bool, error) {
+	values, err := keywordOpts(opts, "append")
+	return truthy(values["append"]), err
+}
+
+func openFile(op, path string, appending bool) (*os.File, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appending {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, ioError(op, path, err)
+	}
+	return file, nil
+}
+
+func spit(e types.Env, a []types.Base) (types.Base, error) {
+	if len(a) < 2 {
+		return nil, errors.New("wrong number of arguments")
+	}
+	path, err := pathArg(e, a, 0, "spit")
+	if err != nil {
+		return nil, err
+	}
+	appending, err := appendOpt(a[2:])
+	if err != nil {
+		return nil, err
+	} else if err := chargePrint(e, printer.Options{}, a[1:2]); err != nil {
+		return nil, err
+	}
+	file, err := openFile("spit", path, appending)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.WriteString(file, printer.Print(a[1], false))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, ioError("spit", path, err)
+	}
+	return nil, nil
+}
+
+func openReader(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	path, err := pathArg(e, a, 0, "reader")
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, ioError("reader", path, err)
+	}
+	return types.NewReadHandle(path, file), nil
+}
+
+func openWriter(e types.Env, a []types.Base) (types.Base, error) {
+	if len(a) < 1 {
+		return nil, errors.New("wrong number of arguments")
+	}
+	path, err := pathArg(e, a, 0, "writer")
+	if err != nil {
+		return nil, err
+	}
+	appending, err := appendOpt(a[1:])
+	if err != nil {
+		return nil, err
+	}
+	file, err := openFile("writer", path, appending)
+	if err != nil {
+		return nil, err
+	}
+	return types.NewWriteHandle(path, file), nil
+}
+
+// handleArg returns the first argument if it is an open reader, or writer when
+// op is write
+func handleArg(a []types.Base, op string) (*types.Handle, error) {
+	h, ok := a[0].(*types.Handle)
+	if !ok || (op == "read" && h.Reader == nil) || (op == "write" && h.Writer == nil) {
+		return nil, fmt.Errorf("%v expects a %ver", op, op)
+	} else if h.Closed() {
+		return nil, ioError(op, h.Path, fs.ErrClosed)
+	}
+	return h, nil
+}
+
+// nextLine reads a line without its line ending. It returns false at the end
+// of the file.
+func nextLine(h *types.Handle) (string, bool, error) {
+	line, err := h.Reader.ReadString('\n')
+	if err == io.EOF {
+		return line, line != "", nil
+	} else if err != nil {
+		return "", false, ioError("read", h.Path, err)
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), true, nil
+}
+
+func readLine(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	h, err := handleArg(a, "read")
+	if err != nil {
+		return nil, err
+	}
+	line, ok, err := nextLine(h)
+	if !ok || err != nil {
+		return nil, err
+	}
+	return line, nil
+}
+
+func write(e types.Env, a []types.Base) (types.Base, error) {
+	if len(a) < 1 {
+		return nil, errors.New("wrong number of arguments")
+	}
+	h, err := handleArg(a, "write")
+	if err != nil {
+		return nil, err
+	}
+	if err := chargePrint(e, printer.Options{}, a[1:]); err != nil {
+		return nil, err
+	}
+	if _, err := h.Writer.WriteString(printer.List(a[1:], false, "", "", "")); err != nil {
+		return nil, ioError("write", h.Path, err)
+	}
+	return nil, nil
+}
+
+func closeHandle(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	h, ok := a[0].(*types.Handle)
+	if !ok {
+		return nil, errors.New("expected a reader or a writer")
+	}
+	if err := h.Close(); err != nil {
+		return nil, ioError("close", h.Path, err)
+	}
+	return nil, nil
+}
+
+func fileExists(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	path, err := pathArg(e, a, 0, "file-exists?")
+	if err != nil {
+		return nil, err
+	}
+	_, err = os.Stat(path)
+	return err == nil, nil
+}
+
+func listDir(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	path, err := pathArg(e, a, 0, "list-dir")
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, ioError("list-dir", path, err)
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	sort.Strings(names)
+	return stringVect(names), nil
+}
+
+func mkdirs(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	path, err := pathArg(e, a, 0, "mkdirs")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, ioError("mkdirs", path, err)
+	}
+	return nil, nil
+}
+
+func deleteFile(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	path, err := pathArg(e, a, 0, "delete-file")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil {
+		return nil, ioError("delete-file", path, err)
+	}
+	return nil, nil
+}
+
+func fileInfo(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	path, err := pathArg(e, a, 0, "file-info")
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, ioError("file-info", path, err)
+	}
+	return types.NewHashmap([]types.Base{
+		types.Keyword("name"), info.Name(),
+		types.Keyword("path"), path,
+		types.Keyword("size"), float64(info.Size()),
+		types.Keyword("directory?"), info.IsDir(),
+		types.Keyword("modified"), float64(info.ModTime().UnixNano() / 1e6),
+		types.Keyword("mode"), info.Mode().String(),
+	})
+}
+
+func tempFile(e types.Env, a []types.Base) (types.Base, error) {
+	if len(a) != 0 && len(a) != 2 {
+		return nil, errors.New("wrong number of arguments")
+	}
+	prefix, suffix := "wot", ""
+	if len(a) == 2 {
+		var err error
+		if prefix, suffix, err = stringArgs(a); err != nil {
+			return nil, err
+		}
+	}
+	if err := allowedPath(e, os.TempDir()); err != nil {
+		return nil, ioError("temp-file", os.TempDir(), err)
+	}
+	file, err := os.CreateTemp("", prefix+"*"+suffix)
+	if err != nil {
+		return nil, ioError("temp-file", os.TempDir(), err)
+	}
+	file.Close()
+	return file.Name(), nil
+}
+
+func tempDir(e types.Env, a []types.Base) (types.Base, error) {
+	if len(a) > 1 {
+		return nil, errors.New("wrong number of arguments")
+	}
+	prefix := "wot"
+	if len(a) == 1 {
+		var err error
+		if prefix, err = stringArg(a, 0); err != nil {
+			return nil, err
+		}
+	}
+	if err := allowedPath(e, os.TempDir()); err != nil {
+		return nil, ioError("temp-dir", os.TempDir(), err)
+	}
+	dir, err := os.MkdirTemp("", prefix)
+	if err != nil {
+		return nil, ioError("temp-dir", os.TempDir(), err)
+	}
+	return dir, nil
+}
+
+func joinPath(e types.Env, a []types.Base) (types.Base, error) {
+	parts := make([]string, len(a))
+	for i := range a {
+		part, err := stringArg(a, i)
+		if err != nil {
+			return nil, err
+		}
+		parts[i] = part
+	}
+	return filepath.Join(parts...), nil
+}
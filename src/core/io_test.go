@@ -0,0 +1,113 @@
+package core
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tanema/mal/src/printer"
+	"github.com/tanema/mal/src/reader"
+	"github.com/tanema/mal/src/runtime"
+	"github.com/tanema/mal/src/types"
+)
+
+// TestAllowedPaths checks that files outside of the allowed paths cannot be
+// read, written or loaded
+func TestAllowedPaths(t *testing.T) {
+	allowed, outside := t.TempDir(), t.TempDir()
+	for _, dir := range []string{allowed, outside} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "lib.mal"), []byte(`(ns lib) (def! loaded "yes")`), 0644); err != nil {
+			t.Fatal(err)
+		} else if err := ioutil.WriteFile(filepath.Join(dir, "dep.mal"), []byte(`(ns dep)`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defaultEnv := DefaultNamespace()
+	if err := SetAllowedPaths(defaultEnv, allowed); err != nil {
+		t.Fatal(err)
+	}
+	eval := func(src string) (string, error) {
+		form, err := reader.ReadString(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		val, err := runtime.Eval(defaultEnv.Current(), form)
+		return printer.Print(val, true), err
+	}
+	for _, src := range []string{
+		`(slurp "%v/lib.mal")`,
+		`(load-file "%v/lib.mal")`,
+		`(io/reader "%v/lib.mal")`,
+		`(io/spit "%v/out.txt" 1)`,
+	} {
+		if _, err := eval(strings.Replace(src, "%v", outside, -1)); err == nil || !strings.Contains(printer.Print(err, true), ":not-allowed") {
+			t.Errorf("%v outside of the allowed paths returned %v", src, err)
+		}
+		if _, err := eval(strings.Replace(src, "%v", allowed, -1)); err != nil {
+			t.Errorf("%v inside of the allowed paths returned %v", src, err)
+		}
+	}
+	other := DefaultNamespace()
+	form, _ := reader.ReadString(`(slurp "` + filepath.Join(outside, "lib.mal") + `")`)
+	if _, err := runtime.Eval(other.Current(), form); err != nil {
+		t.Errorf("the allowed paths of one environment limited another: %v", err)
+	}
+	if val, err := eval("lib/loaded"); err != nil || val != `"yes"` {
+		t.Errorf("loaded = %v, %v", val, err)
+	}
+	defaultEnv.Set("*load-path*", types.NewVect(outside))
+	if _, err := eval("(require 'dep)"); err == nil || !strings.Contains(printer.Print(err, true), ":not-allowed") {
+		t.Errorf("require outside of the allowed paths returned %v", err)
+	}
+	defaultEnv.Set("*load-path*", types.NewVect(allowed))
+	if _, err := eval("(require 'dep)"); err != nil {
+		t.Errorf("require inside of the allowed paths returned %v", err)
+	}
+}
+
+// TestStdin checks that closing *in* in one environment leaves it open in
+// another
+func TestStdin(t *testing.T) {
+	closed, other := BuiltinNamespace(), BuiltinNamespace()
+	forms, _ := reader.ReadAll("(io/close *in*) (json/read)")
+	if _, err := runtime.Eval(closed.Current(), forms[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runtime.Eval(closed.Current(), forms[1]); err == nil || !strings.Contains(printer.Print(err, true), ":closed") {
+		t.Errorf("(json/read) after closing *in* returned %v", err)
+	}
+	if in, ok := lookup(other, "*in*").(*types.Handle); !ok || in.Closed() {
+		t.Errorf("closing *in* in one environment closed it in another")
+	}
+}
+
+// TestIO checks the results of the io functions on files in a temporary
+// directory
+func TestIO(t *testing.T) {
+	dir := t.TempDir()
+	defaultEnv := DefaultNamespace()
+	cases := []struct {
+		src      string
+		expected string
+	}{
+		{`(io/spit "%v/f.txt" "one\ntwo\n")`, `nil`},
+		{`(io/spit "%v/f.txt" "three" :append true)`, `nil`},
+		{`(slurp "%v/f.txt")`, `"one\ntwo\nthree"`},
+		{`(with-open [r (io/reader "%v/f.txt")] [(io/read-line r) (io/read-line r) (io/read-line r) (io/read-line r)])`, `["one" "two" "three" nil]`},
+		{`(with-open [w (io/writer "%v/g.txt")] (io/write w "a" 1)) (slurp "%v/g.txt")`, `"a1"`},
+		{`(io/list-dir "%v")`, `["f.txt" "g.txt"]`},
+		{`(get (io/file-info "%v/f.txt") :size)`, `13`},
+		{`(io/mkdirs "%v/a/b") (io/file-exists? "%v/a/b")`, `true`},
+		{`(io/join-path "a" "b" "c.txt")`, `"a/b/c.txt"`},
+		{`(io/delete-file "%v/g.txt") (io/file-exists? "%v/g.txt")`, `false`},
+		{`(try* (io/reader "%v/missing") (catch* e (get e :reason)))`, `:not-found`},
+		{`(let* [r (io/reader "%v/f.txt")] (do (io/close r) (try* (io/read-line r) (catch* e (get e :reason)))))`, `:closed`},
+	}
+	for _, c := range cases {
+		src := strings.Replace(c.src, "%v", filepath.ToSlash(dir), -1)
+		if val, err := evalPrint(defaultEnv, src); err != nil || val != c.expected {
+			t.Errorf("%v returned %v, %v, expected %v", c.src, val, err, c.expected)
+		}
+	}
+}
@@ -0,0 +1,432 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tanema/mal/src/printer"
+	"github.com/tanema/mal/src/types"
+)
+
+// JSONNs is the namespace of the JSON functions, like json/read-str
+const JSONNs = "json"
+
+// maxJSONDepth is how deeply arrays and objects can be nested in JSON that is
+// read
+const maxJSONDepth = 10000
+
+// newStdin returns a reader of the standard input to bind to *in*. Each
+// environment gets its own so that closing it only closes it there, and
+// closing it does not close the standard input of the process.
+func newStdin() *types.Handle {
+	return types.NewReadHandle("*in*", ioutil.NopCloser(byteReader{os.Stdin}))
+}
+
+// byteReader reads one byte at a time so that reading a value from *in* does
+// not buffer input that comes after it, which the REPL would then miss
+type byteReader struct {
+	r io.Reader
+}
+
+func (b byteReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return b.r.Read(p)
+}
+
+var jsonNamespace = map[types.Symbol]*types.StdFunc{
+	"read-str":  types.Func(jsonReadStr),
+	"read":      types.Func(jsonRead),
+	"write-str": types.Func(jsonWriteStr),
+	"write":     types.Func(jsonWrite),
+}
+
+var jsonDocs = map[types.Symbol][2]string{
+	"read-str":  {"([s & opts])", "Reads the JSON value in s. Objects become maps, arrays become vectors\nand null becomes nil. :key-fn is called on each object key, like\n(json/read-str s :key-fn keyword)."},
+	"read":      {"([& opts] [rdr & opts])", "Reads the next JSON value from the reader, or *in*, leaving the rest of\nthe input to be read. Returns the :eof-value option, nil by default, at\nthe end of the input. Takes :key-fn like read-str."},
+	"write-str": {"([x & opts])", "Returns x written as JSON. Keywords and symbols are written as strings.\nWith :pretty true it is indented over several lines and with\n:sort-keys true the keys of objects are sorted."},
+	"write":     {"([wtr x & opts])", "Writes x as JSON to the writer. Takes the same options as write-str."},
+}
+
+func init() {
+	documentAll(jsonNamespace, jsonDocs)
+}
+
+// jsonReader builds values from the tokens of a JSON decoder
+type jsonReader struct {
+	dec   *json.Decoder
+	env   types.Env
+	keyFn types.Base
+	depth int
+}
+
+func newJSONReader(e types.Env, src []byte, opts []types.Base, names ...types.Keyword) (*jsonReader, error) {
+	values, err := keywordOpts(opts, append(names, "key-fn")...)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(src))
+	dec.UseNumber()
+	return &jsonReader{dec: dec, env: e, keyFn: values["key-fn"]}, nil
+}
+
+// read reads the only value in the source
+func (r *jsonReader) read() (types.Base, error) {
+	val, err := r.value()
+	if err == io.EOF {
+		return nil, errors.New("invalid JSON: unexpected end of input")
+	} else if err != nil {
+		return nil, err
+	} else if _, err := r.dec.Token(); err != io.EOF {
+		return nil, errors.New("invalid JSON: unexpected data after the value")
+	}
+	return val, nil
+}
+
+func (r *jsonReader) value() (types.Base, error) {
+	tok, err := r.dec.Token()
+	if err == io.EOF {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	switch ttok := tok.(type) {
+	case json.Delim:
+		if r.depth++; r.depth > maxJSONDepth {
+			return nil, errors.New("invalid JSON: nested too deeply")
+		}
+		defer func() { r.depth-- }()
+		if ttok == '[' {
+			return r.array()
+		}
+		return r.object()
+	case json.Number:
+		num, err := strconv.ParseFloat(string(ttok), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON: number out of range %v", ttok)
+		}
+		return num, nil
+	default:
+		return ttok, nil
+	}
+}
+
+func (r *jsonReader) array() (types.Base, error) {
+	forms := []types.Base{}
+	for r.dec.More() {
+		val, err := r.value()
+		if err != nil {
+			return nil, r.unexpectedEOF(err)
+		}
+		forms = append(forms, val)
+	}
+	if _, err := r.dec.Token(); err != nil {
+		return nil, r.unexpectedEOF(err)
+	}
+	return types.NewVect(forms...), nil
+}
+
+func (r *jsonReader) object() (types.Base, error) {
+	pairs := []types.Base{}
+	for r.dec.More() {
+		tok, err := r.dec.Token()
+		if err != nil {
+			return nil, r.unexpectedEOF(err)
+		}
+		var key types.Base = tok
+		if r.keyFn != nil {
+			if key, err = types.CallFunc(r.env, r.keyFn, []types.Base{key}); err != nil {
+				return nil, err
+			}
+		}
+		val, err := r.value()
+		if err != nil {
+			return nil, r.unexpectedEOF(err)
+		}
+		pairs = append(pairs, key, val)
+	}
+	if _, err := r.dec.Token(); err != nil {
+		return nil, r.unexpectedEOF(err)
+	}
+	return types.NewHashmap(pairs)
+}
+
+func (r *jsonReader) unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return errors.New("invalid JSON: unexpected end of input")
+	} else if _, isSyntax := err.(*json.SyntaxError); isSyntax {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+	return err
+}
+
+func jsonReadStr(e types.Env, a []types.Base) (types.Base, error) {
+	if len(a) < 1 {
+		return nil, errors.New("wrong number of arguments")
+	}
+	src, err := stringArg(a, 0)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newJSONReader(e, []byte(src), a[1:])
+	if err != nil {
+		return nil, err
+	}
+	return r.read()
+}
+
+func jsonRead(e types.Env, a []types.Base) (types.Base, error) {
+	in, opts := lookup(e, "*in*"), a
+	if len(a)%2 == 1 {
+		in, opts = a[0], a[1:]
+	}
+	h, err := handleArg([]types.Base{in}, "read")
+	if err != nil {
+		return nil, err
+	}
+	src, err := scanJSON(h.Reader)
+	if err == io.EOF {
+		values, err := keywordOpts(opts, "key-fn", "eof-value")
+		return values["eof-value"], err
+	} else if err != nil {
+		return nil, ioError("read", h.Path, err)
+	}
+	r, err := newJSONReader(e, src, opts, "eof-value")
+	if err != nil {
+		return nil, err
+	}
+	return r.read()
+}
+
+// scanJSON reads the bytes of the next JSON value from the reader and no
+// further, so that the rest of the input can still be read. It returns io.EOF
+// if there is only whitespace left.
+func scanJSON(rdr *bufio.Reader) ([]byte, error) {
+	first, err := skipSpace(rdr)
+	if err != nil {
+		return nil, err
+	}
+	buf := []byte{first}
+	depth, inString, escaped := 0, first == '"', false
+	if first == '{' || first == '[' {
+		depth = 1
+	} else if !inString {
+		for {
+			c, err := rdr.ReadByte()
+			if err == io.EOF {
+				return buf, nil
+			} else if err != nil {
+				return nil, err
+			} else if strings.IndexByte(" \t\r\n,:]}[{\"", c) >= 0 {
+				return buf, rdr.UnreadByte()
+			}
+			buf = append(buf, c)
+		}
+	}
+	for {
+		c, err := rdr.ReadByte()
+		if err == io.EOF {
+			return buf, nil
+		} else if err != nil {
+			return nil, err
+		}
+		buf = append(buf, c)
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+			if !inString && depth == 0 {
+				return buf, nil
+			}
+		case inString:
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			if depth--; depth == 0 {
+				return buf, nil
+			}
+		}
+	}
+}
+
+func skipSpace(rdr *bufio.Reader) (byte, error) {
+	for {
+		c, err := rdr.ReadByte()
+		if err != nil {
+			return 0, err
+		} else if strings.IndexByte(" \t\r\n", c) < 0 {
+			return c, nil
+		}
+	}
+}
+
+// jsonWriter writes values as JSON
+type jsonWriter struct {
+	out      strings.Builder
+	pretty   bool
+	sortKeys bool
+}
+
+func newJSONWriter(opts []types.Base) (*jsonWriter, error) {
+	values, err := keywordOpts(opts, "pretty", "sort-keys")
+	if err != nil {
+		return nil, err
+	}
+	return &jsonWriter{pretty: truthy(values["pretty"]), sortKeys: truthy(values["sort-keys"])}, nil
+}
+
+func (w *jsonWriter) write(val types.Base, indent string) error {
+	switch tval := val.(type) {
+	case nil:
+		w.out.WriteString("null")
+	case bool:
+		w.out.WriteString(printer.Print(tval, false))
+	case float64:
+		if math.IsInf(tval, 0) || math.IsNaN(tval) {
+			return fmt.Errorf("cannot write %v as JSON", tval)
+		}
+		w.out.WriteString(printer.Print(tval, false))
+	case string, types.Keyword, types.Symbol, types.Char:
+		w.out.WriteString(jsonString(jsonName(tval)))
+	case types.Collection:
+		return w.array(tval.Data(), indent)
+	case *types.Hashmap:
+		return w.object(tval, indent)
+	default:
+		return fmt.Errorf("cannot write %v as JSON", printer.Print(tval, true))
+	}
+	return nil
+}
+
+func (w *jsonWriter) array(forms []types.Base, indent string) error {
+	if len(forms) == 0 {
+		w.out.WriteString("[]")
+		return nil
+	}
+	w.out.WriteByte('[')
+	for i, form := range forms {
+		w.separate(i, indent+"  ")
+		if err := w.write(form, indent+"  "); err != nil {
+			return err
+		}
+	}
+	w.separate(-1, indent)
+	w.out.WriteByte(']')
+	return nil
+}
+
+func (w *jsonWriter) object(hm *types.Hashmap, indent string) error {
+	if len(hm.Forms) == 0 {
+		w.out.WriteString("{}")
+		return nil
+	}
+	keys := make([]string, 0, len(hm.Forms))
+	vals := make(map[string]types.Base, len(hm.Forms))
+	for _, key := range hm.Keys() {
+		var name string
+		switch tkey := key.(type) {
+		case string, types.Keyword, types.Symbol, types.Char, float64:
+			name = jsonName(tkey)
+		default:
+			return fmt.Errorf("cannot write %v as a JSON key", printer.Print(key, true))
+		}
+		if _, duplicate := vals[name]; duplicate {
+			return fmt.Errorf("cannot write %v as JSON, more than one key is written as %v", printer.Print(hm, true), jsonString(name))
+		}
+		keys = append(keys, name)
+		vals[name] = hm.Forms[key]
+	}
+	if w.sortKeys {
+		sort.Strings(keys)
+	}
+	w.out.WriteByte('{')
+	for i, key := range keys {
+		w.separate(i, indent+"  ")
+		w.out.WriteString(jsonString(key))
+		w.out.WriteByte(':')
+		if w.pretty {
+			w.out.WriteByte(' ')
+		}
+		if err := w.write(vals[key], indent+"  "); err != nil {
+			return err
+		}
+	}
+	w.separate(-1, indent)
+	w.out.WriteByte('}')
+	return nil
+}
+
+// separate writes what comes before item i of an array or object, or before
+// its closing bracket when i is -1
+func (w *jsonWriter) separate(i int, indent string) {
+	if i > 0 {
+		w.out.WriteByte(',')
+	}
+	if w.pretty {
+		w.out.WriteString("\n" + indent)
+	}
+}
+
+// jsonName is the string that a keyword, symbol or other simple value is
+// written as, without the colon of a keyword
+func jsonName(val types.Base) string {
+	if kw, ok := val.(types.Keyword); ok {
+		return string(kw)
+	}
+	return printer.Print(val, false)
+}
+
+func jsonString(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func jsonWriteStr(e types.Env, a []types.Base) (types.Base, error) {
+	if len(a) < 1 {
+		return nil, errors.New("wrong number of arguments")
+	}
+	w, err := newJSONWriter(a[1:])
+	if err != nil {
+		return nil, err
+	} else if err := w.write(a[0], ""); err != nil {
+		return nil, err
+	}
+	return w.out.String(), nil
+}
+
+func jsonWrite(e types.Env, a []types.Base) (types.Base, error) {
+	if len(a) < 2 {
+		return nil, errors.New("wrong number of arguments")
+	}
+	h, err := handleArg(a, "write")
+	if err != nil {
+		return nil, err
+	}
+	w, err := newJSONWriter(a[2:])
+	if err != nil {
+		return nil, err
+	} else if err := w.write(a[1], ""); err != nil {
+		return nil, err
+	}
+	if _, err := h.Writer.WriteString(w.out.String()); err != nil {
+		return nil, ioError("write", h.Path, err)
+	}
+	return nil, nil
+}
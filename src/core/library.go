@@ -0,0 +1,84 @@
+package core
+
+import (
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/tanema/mal/lib"
+	"github.com/tanema/mal/src/types"
+)
+
+// libPrefix marks the paths of files that are read from a bundled library
+// instead of the disk, like lib:wot/core.mal
+const libPrefix = "lib:"
+
+// Library is a set of wot source files that is shipped inside of the binary.
+// Namespaces in a library can be required as if their files were on disk, and
+// the namespaces in Preload are loaded into every new environment.
+type Library struct {
+	FS      fs.FS
+	Preload []types.Symbol
+}
+
+var libraries []Library
+
+func init() {
+	RegisterLibrary(Library{FS: lib.FS, Preload: []types.Symbol{CoreNs}})
+}
+
+// RegisterLibrary makes a library available to every environment created after
+// it is registered. Hosts embedding the interpreter can call it from an init
+// function with an embed.FS to bundle their own namespaces. Libraries are
+// searched in the order they were registered, after the files on disk.
+func RegisterLibrary(library Library) {
+	libraries = append(libraries, library)
+}
+
+// preload loads the preloaded namespaces of every library
+func (l *loader) preload() error {
+	for _, library := range libraries {
+		for _, name := range library.Preload {
+			if _, err := l.load(libPrefix + path.Join(strings.Split(string(name), ".")...) + ".mal"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isLibPath checks if the path is in a bundled library
+func isLibPath(p string) bool {
+	return strings.HasPrefix(p, libPrefix)
+}
+
+// resolveLib finds the first library that has the file in the library directory
+func resolveLib(dir, p string) (string, bool) {
+	candidate := path.Join(strings.TrimPrefix(dir, libPrefix), filepath.ToSlash(p))
+	if !fs.ValidPath(candidate) {
+		return "", false
+	}
+	for _, library := range libraries {
+		if info, err := fs.Stat(library.FS, candidate); err == nil && !info.IsDir() {
+			return libPrefix + candidate, true
+		}
+	}
+	return "", false
+}
+
+// readSource reads a file from the disk or from a bundled library
+func readSource(p string) ([]byte, error) {
+	if !isLibPath(p) {
+		return ioutil.ReadFile(p)
+	}
+	name := strings.TrimPrefix(p, libPrefix)
+	for _, library := range libraries {
+		if source, err := fs.ReadFile(library.FS, name); err == nil {
+			return source, nil
+		}
+	}
+	return nil, fmt.Errorf("%v is not in any bundled library", name)
+}
@@ -0,0 +1,138 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tanema/mal/src/env"
+	"github.com/tanema/mal/src/reader"
+	"github.com/tanema/mal/src/runtime"
+	"github.com/tanema/mal/src/types"
+)
+
+// loader loads files and namespaces into an environment. It keeps track of the
+// files that are currently loading so that *file* can be bound while each file
+// is evaluated and so that include cycles can be reported, along with the
+// namespace that each of them declares so that require cycles can be too.
+type loader struct {
+	env        *env.Env
+	loading    []string
+	namespaces []types.Symbol
+}
+
+func (l *loader) loadFile(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	path, ok := a[0].(string)
+	if !ok {
+		return nil, errors.New("cannot load file from non-string path")
+	}
+	base := ""
+	if current, ok := lookup(l.env, "*file*").(string); ok {
+		base = filepath.Dir(current)
+	}
+	resolved, err := resolvePath(l.env, path, base)
+	if err != nil {
+		return nil, err
+	}
+	return l.load(resolved)
+}
+
+// load evaluates every form in the file in the current namespace. The current
+// namespace and *file* are restored once the file is done. Files on the disk
+// have to be inside of the allowed paths.
+func (l *loader) load(path string) (types.Base, error) {
+	for i, file := range l.loading {
+		if file == path {
+			return nil, fmt.Errorf("load-file cycle detected: %v", strings.Join(append(l.loading[i:], path), " -> "))
+		}
+	}
+	if !isLibPath(path) {
+		if err := allowedPath(l.env, path); err != nil {
+			return nil, ioError("load-file", path, err)
+		}
+	}
+	source, err := readSource(path)
+	if err != nil {
+		return nil, fmt.Errorf("problem reading source file: %v", err)
+	}
+	forms, err := reader.ReadAll(string(source))
+	if err != nil {
+		return nil, err
+	}
+
+	registry := l.env.Namespace().Registry()
+	prevNs := registry.Current()
+	prevFile, _ := l.env.Get("*file*")
+	l.env.Set("*file*", path)
+	l.loading = append(l.loading, path)
+	l.namespaces = append(l.namespaces, "")
+	defer func() {
+		l.loading = l.loading[:len(l.loading)-1]
+		l.namespaces = l.namespaces[:len(l.namespaces)-1]
+		l.env.Set("*file*", prevFile)
+		registry.SetCurrent(prevNs)
+	}()
+
+	var result types.Base
+	for _, form := range forms {
+		if result, err = runtime.Eval(l.env.Current(), form); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// resolvePath finds the file to load for a path. Absolute paths are used as is,
+// relative paths are searched for in the base directory, the working directory,
+// each directory in *load-path* and then in the bundled libraries.
+func resolvePath(e types.Env, path, base string) (string, error) {
+	resolved, _, err := resolveFrom(e, path, base)
+	return resolved, err
+}
+
+// resolveFrom resolves a path like resolvePath and also returns the directory
+// that it was found in. Directories that start with lib: are in the bundled
+// libraries.
+func resolveFrom(e types.Env, path, base string) (string, string, error) {
+	if filepath.IsAbs(path) || isLibPath(path) {
+		return path, filepath.Dir(path), nil
+	}
+	dirs := []string{}
+	if base != "" {
+		dirs = append(dirs, base)
+	}
+	dirs = append(dirs, "")
+	if loadPath, ok := lookup(e, "*load-path*").(types.Collection); ok {
+		for _, dir := range loadPath.Data() {
+			if dirPath, ok := dir.(string); ok {
+				dirs = append(dirs, dirPath)
+			}
+		}
+	}
+	dirs = append(dirs, libPrefix)
+	for _, dir := range dirs {
+		if isLibPath(dir) {
+			if resolved, ok := resolveLib(dir, path); ok {
+				return resolved, dir, nil
+			}
+		} else if info, err := os.Stat(filepath.Join(dir, path)); err == nil && !info.IsDir() {
+			resolved, err := filepath.Abs(filepath.Join(dir, path))
+			if err != nil {
+				return "", "", err
+			}
+			absDir, err := filepath.Abs(dir)
+			return resolved, absDir, err
+		}
+	}
+	return "", "", fmt.Errorf("could not find %v in the current directory, *load-path* or bundled libraries", path)
+}
+
+func lookup(e types.Env, name types.Symbol) types.Base {
+	val, _ := e.Get(name)
+	return val
+}
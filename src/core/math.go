@@ -0,0 +1,361 @@
+package core
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/tanema/mal/src/env"
+	"github.com/tanema/mal/src/types"
+)
+
+// MathNs is the namespace of the math functions, like math/sqrt
+const MathNs = "math"
+
+// maxExactInt is the largest integer that every smaller integer can be held
+// exactly in a number
+const maxExactInt = 1 << 53
+
+var mathNamespace = map[types.Symbol]*types.StdFunc{
+	"abs":             types.Func(unaryMath(math.Abs)),
+	"floor":           types.Func(unaryMath(math.Floor)),
+	"ceil":            types.Func(unaryMath(math.Ceil)),
+	"round":           types.Func(unaryMath(math.Round)),
+	"sqrt":            types.Func(unaryMath(math.Sqrt)),
+	"exp":             types.Func(unaryMath(math.Exp)),
+	"log":             types.Func(unaryMath(math.Log)),
+	"log10":           types.Func(unaryMath(math.Log10)),
+	"sin":             types.Func(unaryMath(math.Sin)),
+	"cos":             types.Func(unaryMath(math.Cos)),
+	"tan":             types.Func(unaryMath(math.Tan)),
+	"asin":            types.Func(unaryMath(math.Asin)),
+	"acos":            types.Func(unaryMath(math.Acos)),
+	"atan":            types.Func(unaryMath(math.Atan)),
+	"sinh":            types.Func(unaryMath(math.Sinh)),
+	"cosh":            types.Func(unaryMath(math.Cosh)),
+	"tanh":            types.Func(unaryMath(math.Tanh)),
+	"pow":             types.Func(binaryMath(math.Pow)),
+	"atan2":           types.Func(binaryMath(math.Atan2)),
+	"quot":            types.Func(quot),
+	"rem":             types.Func(rem),
+	"mod":             types.Func(mod),
+	"min":             types.Func(foldMath(math.Min)),
+	"max":             types.Func(foldMath(math.Max)),
+	"inf?":            types.Func(isinf),
+	"nan?":            types.Func(isnan),
+	"bit-and":         types.Func(bitMath(func(x, y int64) int64 { return x & y })),
+	"bit-or":          types.Func(bitMath(func(x, y int64) int64 { return x | y })),
+	"bit-xor":         types.Func(bitMath(func(x, y int64) int64 { return x ^ y })),
+	"bit-and-not":     types.Func(bitMath(func(x, y int64) int64 { return x &^ y })),
+	"bit-not":         types.Func(bitnot),
+	"bit-shift-left":  types.Func(shiftMath(func(x int64, n uint) int64 { return x << n })),
+	"bit-shift-right": types.Func(shiftMath(func(x int64, n uint) int64 { return x >> n })),
+}
+
+var mathValues = map[types.Symbol]types.Base{
+	"pi": math.Pi,
+	"e":  math.E,
+}
+
+var mathDocs = map[types.Symbol][2]string{
+	"abs":             {"([x])", "Returns the absolute value of x."},
+	"floor":           {"([x])", "Returns the largest whole number less than or equal to x."},
+	"ceil":            {"([x])", "Returns the smallest whole number greater than or equal to x."},
+	"round":           {"([x])", "Returns x rounded to the nearest whole number, rounding halves away\nfrom zero."},
+	"sqrt":            {"([x])", "Returns the square root of x."},
+	"exp":             {"([x])", "Returns e raised to the power of x."},
+	"log":             {"([x])", "Returns the natural logarithm of x."},
+	"log10":           {"([x])", "Returns the base 10 logarithm of x."},
+	"sin":             {"([x])", "Returns the sine of x radians."},
+	"cos":             {"([x])", "Returns the cosine of x radians."},
+	"tan":             {"([x])", "Returns the tangent of x radians."},
+	"asin":            {"([x])", "Returns the arcsine of x in radians."},
+	"acos":            {"([x])", "Returns the arccosine of x in radians."},
+	"atan":            {"([x])", "Returns the arctangent of x in radians."},
+	"sinh":            {"([x])", "Returns the hyperbolic sine of x."},
+	"cosh":            {"([x])", "Returns the hyperbolic cosine of x."},
+	"tanh":            {"([x])", "Returns the hyperbolic tangent of x."},
+	"pow":             {"([x y])", "Returns x raised to the power of y."},
+	"atan2":           {"([y x])", "Returns the angle in radians of the point x, y from the x axis."},
+	"quot":            {"([x y])", "Returns x divided by y rounded towards zero."},
+	"rem":             {"([x y])", "Returns the remainder of dividing x by y, with the sign of x."},
+	"mod":             {"([x y])", "Returns x modulo y, with the sign of y."},
+	"min":             {"([x & more])", "Returns the smallest of the numbers."},
+	"max":             {"([x & more])", "Returns the largest of the numbers."},
+	"inf?":            {"([x])", "Returns true if x is positive or negative infinity."},
+	"nan?":            {"([x])", "Returns true if x is not a number, like (/ 0 0)."},
+	"bit-and":         {"([x y])", "Returns the bitwise and of the integers x and y."},
+	"bit-or":          {"([x y])", "Returns the bitwise or of the integers x and y."},
+	"bit-xor":         {"([x y])", "Returns the bitwise exclusive or of the integers x and y."},
+	"bit-and-not":     {"([x y])", "Returns the bits of the integer x that are not set in y."},
+	"bit-not":         {"([x])", "Returns the bitwise complement of the integer x."},
+	"bit-shift-left":  {"([x n])", "Returns the integer x shifted left by n bits."},
+	"bit-shift-right": {"([x n])", "Returns the integer x shifted right by n bits, keeping its sign."},
+	"rand":            {"([] [n])", "Returns a random number from 0 up to, but not including, n or 1."},
+	"rand-int":        {"([n])", "Returns a random whole number from 0 up to, but not including, n."},
+	"rand-nth":        {"([coll])", "Returns a random item from the collection."},
+	"shuffle":         {"([coll])", "Returns a vector of the items in coll in a random order."},
+	"seed!":           {"([n])", "Seeds the random numbers of this interpreter so that the numbers that\nfollow can be repeated."},
+}
+
+func init() {
+	documentAll(mathNamespace, mathDocs)
+}
+
+// random is the source of random numbers for the functions in the math
+// namespace. Every interpreter has its own so that seeding it does not change
+// the numbers of any other.
+type random struct {
+	src *rand.Rand
+}
+
+// defineRandom adds the random number functions to the math namespace of the
+// environment with a new source seeded from the time
+func defineRandom(mathEnv *env.Env) {
+	r := &random{src: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	for name, fn := range map[types.Symbol]func(types.Env, []types.Base) (types.Base, error){
+		"rand":     r.rand,
+		"rand-int": r.randInt,
+		"rand-nth": r.randNth,
+		"shuffle":  r.shuffle,
+		"seed!":    r.seed,
+	} {
+		define(mathEnv, name, fn, mathDocs[name][0], mathDocs[name][1])
+	}
+}
+
+func (r *random) rand(e types.Env, a []types.Base) (types.Base, error) {
+	if len(a) == 0 {
+		return r.src.Float64(), nil
+	} else if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	n, err := floatArg(a, 0)
+	if err != nil {
+		return nil, err
+	}
+	return r.src.Float64() * n, nil
+}
+
+func (r *random) randInt(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	n, err := floatArg(a, 0)
+	if err != nil {
+		return nil, err
+	} else if n < 1 || n > maxExactInt {
+		return nil, errors.New("rand-int expects a number from 1 to 2^53")
+	}
+	return float64(r.src.Int63n(int64(n))), nil
+}
+
+func (r *random) randNth(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	col, ok := a[0].(types.Collection)
+	if !ok {
+		return nil, errors.New("rand-nth expects a collection")
+	}
+	data := col.Data()
+	if len(data) == 0 {
+		return nil, errors.New("rand-nth of an empty collection")
+	}
+	return data[r.src.Intn(len(data))], nil
+}
+
+func (r *random) shuffle(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	col, ok := a[0].(types.Collection)
+	if !ok {
+		return nil, errors.New("shuffle expects a collection")
+	}
+	data := append([]types.Base{}, col.Data()...)
+	r.src.Shuffle(len(data), func(i, j int) { data[i], data[j] = data[j], data[i] })
+	return types.NewVect(data...), nil
+}
+
+func (r *random) seed(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	n, err := intArg(a, 0)
+	if err != nil {
+		return nil, err
+	}
+	r.src.Seed(n)
+	return nil, nil
+}
+
+func unaryMath(fn func(float64) float64) func(types.Env, []types.Base) (types.Base, error) {
+	return func(e types.Env, a []types.Base) (types.Base, error) {
+		if err := assertArgNum(a, 1); err != nil {
+			return nil, err
+		}
+		x, err := floatArg(a, 0)
+		if err != nil {
+			return nil, err
+		}
+		return fn(x), nil
+	}
+}
+
+func binaryMath(fn func(float64, float64) float64) func(types.Env, []types.Base) (types.Base, error) {
+	return func(e types.Env, a []types.Base) (types.Base, error) {
+		x, y, err := numberArgs(a, "calculate with")
+		if err != nil {
+			return nil, err
+		}
+		return fn(x, y), nil
+	}
+}
+
+// foldMath combines one or more numbers with fn, like min and max
+func foldMath(fn func(float64, float64) float64) func(types.Env, []types.Base) (types.Base, error) {
+	return func(e types.Env, a []types.Base) (types.Base, error) {
+		if len(a) == 0 {
+			return nil, errors.New("wrong number of arguments")
+		}
+		result, err := floatArg(a, 0)
+		if err != nil {
+			return nil, err
+		}
+		for i := 1; i < len(a); i++ {
+			x, err := floatArg(a, i)
+			if err != nil {
+				return nil, err
+			}
+			result = fn(result, x)
+		}
+		return result, nil
+	}
+}
+
+func bitMath(fn func(int64, int64) int64) func(types.Env, []types.Base) (types.Base, error) {
+	return func(e types.Env, a []types.Base) (types.Base, error) {
+		if err := assertArgNum(a, 2); err != nil {
+			return nil, err
+		}
+		x, err := intArg(a, 0)
+		if err != nil {
+			return nil, err
+		}
+		y, err := intArg(a, 1)
+		if err != nil {
+			return nil, err
+		}
+		return float64(fn(x, y)), nil
+	}
+}
+
+func shiftMath(fn func(int64, uint) int64) func(types.Env, []types.Base) (types.Base, error) {
+	return func(e types.Env, a []types.Base) (types.Base, error) {
+		if err := assertArgNum(a, 2); err != nil {
+			return nil, err
+		}
+		x, err := intArg(a, 0)
+		if err != nil {
+			return nil, err
+		}
+		n, err := indexArg(a, 1)
+		if err != nil {
+			return nil, err
+		} else if n > 63 {
+			return nil, errors.New("cannot shift by more than 63 bits")
+		}
+		return float64(fn(x, uint(n))), nil
+	}
+}
+
+func bitnot(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	x, err := intArg(a, 0)
+	if err != nil {
+		return nil, err
+	}
+	return float64(^x), nil
+}
+
+func isinf(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	x, err := floatArg(a, 0)
+	if err != nil {
+		return nil, err
+	}
+	return math.IsInf(x, 0), nil
+}
+
+func isnan(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	x, err := floatArg(a, 0)
+	if err != nil {
+		return nil, err
+	}
+	return math.IsNaN(x), nil
+}
+
+func floatArg(a []types.Base, i int) (float64, error) {
+	x, ok := a[i].(float64)
+	if !ok {
+		return 0, errors.New("expected a number")
+	}
+	return x, nil
+}
+
+// intArg returns the argument as an int64 if it is a whole number that a
+// number can hold exactly
+func intArg(a []types.Base, i int) (int64, error) {
+	x, err := floatArg(a, i)
+	if err != nil {
+		return 0, err
+	} else if x != math.Trunc(x) || math.Abs(x) > maxExactInt {
+		return 0, errors.New("expected an integer")
+	}
+	return int64(x), nil
+}
+
+func quot(e types.Env, a []types.Base) (types.Base, error) {
+	x, y, err := divisionArgs(a)
+	if err != nil {
+		return nil, err
+	}
+	return math.Trunc(x / y), nil
+}
+
+func rem(e types.Env, a []types.Base) (types.Base, error) {
+	x, y, err := divisionArgs(a)
+	if err != nil {
+		return nil, err
+	}
+	return math.Mod(x, y), nil
+}
+
+func mod(e types.Env, a []types.Base) (types.Base, error) {
+	x, y, err := divisionArgs(a)
+	if err != nil {
+		return nil, err
+	}
+	r := math.Mod(x, y)
+	if r != 0 && (r < 0) != (y < 0) {
+		r += y
+	}
+	return r, nil
+}
+
+func divisionArgs(a []types.Base) (float64, float64, error) {
+	x, y, err := numberArgs(a, "divide")
+	if err == nil && y == 0 {
+		err = errors.New("divide by zero")
+	}
+	return x, y, err
+}
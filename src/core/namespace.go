@@ -6,23 +6,101 @@ import (
 	"github.com/tanema/mal/src/types"
 )
 
+// CoreNs is the namespace that the core functions are defined in. Every other
+// namespace can use them without requiring it.
+const CoreNs = "wot.core"
+
+// builtinNamespaces are the namespaces besides the core namespace that have
+// functions written in Go. They exist in every environment so their functions
+// can be used qualified, like string/join, or required with an alias.
+var builtinNamespaces = map[types.Symbol]map[types.Symbol]*types.StdFunc{
+	StringNs: stringNamespace,
+	MathNs:   mathNamespace,
+	IONs:     ioNamespace,
+	JSONNs:   jsonNamespace,
+	EDNNs:    ednNamespace,
+}
+
+// builtinValues are the values besides functions that are defined in the
+// builtin namespaces, like math/pi
+var builtinValues = map[types.Symbol]map[types.Symbol]types.Base{
+	MathNs: mathValues,
+}
+
+// UserNs is the namespace that is current when an environment is created
+const UserNs = "user"
+
 // DefaultNamespace generate an evironment with the core function and variable declarations defined
+// along with the preloaded namespaces of the bundled libraries, like the standard library.
+// The env that is returned is the env of the core namespace, top level forms
+// should be evaluated in its Current() env which starts out as the user namespace.
 func DefaultNamespace() *env.Env {
-	defaultEnv, _ := env.New(nil, nil, nil)
+	defaultEnv := BuiltinNamespace()
+	ev(defaultEnv, `(def! not "Returns true if x is false or nil, otherwise false." (fn* (x) (if x false true)))`)
+	ev(defaultEnv, `(defmacro! cond "Takes pairs of tests and expressions and evaluates the expression of the first test that is true." (fn* (& xs) (if (> (count xs) 0) (list 'if (first xs) (if (> (count xs) 1) (nth xs 1) (throw "odd number of forms to cond")) (cons 'cond (rest (rest xs)))))))`)
+	ev(defaultEnv, "(def! *gensym-counter* (atom 0))")
+	ev(defaultEnv, `(def! gensym "Returns a new symbol with a unique name." (fn* [] (symbol (str "G__" (swap! *gensym-counter* (fn* [x] (+ 1 x)))))))`)
+	ev(defaultEnv, "(defmacro! or \"Evaluates the expressions one at a time and returns the first value that is true.\" (fn* (& xs) (if (empty? xs) nil (if (= 1 (count xs)) (first xs) (let* (condvar (gensym)) `(let* (~condvar ~(first xs)) (if ~condvar ~condvar (or ~@(rest xs)))))))))")
+	ev(defaultEnv, "(defmacro! defn \"Defines a function with an optional docstring, (defn name doc? [params] body).\" (fn* (name & body) (if (string? (first body)) `(def! ~name ~(first body) (fn* ~(nth body 1) (do ~@(rest (rest body))))) `(def! ~name (fn* ~(first body) (do ~@(rest body)))))))")
+	ev(defaultEnv, "(defmacro! doc \"Prints the documentation of the function, macro or special form named by sym.\" (fn* (sym) `(print-doc '~sym)))")
+	ev(defaultEnv, "(defmacro! defn- \"Defines a function that is private to the current namespace.\" (fn* (name & body) `(defn ~(list 'with-meta name :private) ~@body)))")
+	ev(defaultEnv, "(defmacro! ns \"Declares the namespace for the rest of the file, (ns name doc? (:require specs*)).\" (fn* (name & clauses) `(ns* '~name '~clauses)))")
+	ev(defaultEnv, "(defmacro! source \"Prints the source of the function named by sym.\" (fn* (sym) `(println (or (source-fn '~sym) \"Source not found\"))))")
+	if err := (&loader{env: defaultEnv}).preload(); err != nil {
+		panic(err)
+	}
+	registry := defaultEnv.Namespace().Registry()
+	registry.SetCurrent(registry.Create(UserNs))
+	return defaultEnv
+}
+
+// BuiltinNamespace creates an environment with only the functions that are
+// written in Go defined. It is the base that images are loaded into. The core
+// namespace is the current namespace.
+func BuiltinNamespace() *env.Env {
+	defaultEnv := env.NewRegistry(CoreNs)
 	for method, fn := range namespace {
 		defaultEnv.Set(method, fn)
 	}
 	defaultEnv.Set("eval", eval(defaultEnv))
+	registry := defaultEnv.Namespace().Registry()
+	for name, fns := range builtinNamespaces {
+		nsEnv := registry.Create(name).Env()
+		for method, fn := range fns {
+			nsEnv.Set(method, fn)
+		}
+		for sym, val := range builtinValues[name] {
+			nsEnv.Set(sym, val)
+		}
+	}
+	defineRandom(registry.Find(MathNs).Env())
+	l := &loader{env: defaultEnv}
+	define(defaultEnv, "load-file", l.loadFile, "([path])", "Reads and evaluates every form in the file at path. Relative paths are\nlooked up next to the file being loaded, then in the working directory and\nthen in each directory of *load-path*.")
+	define(defaultEnv, "require", l.require, "([& specs])", "Loads namespaces and makes them available in the current namespace. A\nspec is a namespace name or a vector like [my.util :as u :refer [helper]].\nThe namespace my.util is loaded from my/util.mal.")
+	define(defaultEnv, "in-ns", l.inNs, "([name])", "Switches to the namespace with the name, creating it if needed.")
+	define(defaultEnv, "ns*", l.declareNs, "([name clauses])", "Switches to the namespace and processes the ns clauses. Used by ns.")
+	define(defaultEnv, "refer", l.refer, "([ns] [ns :only syms])", "Makes the public definitions of ns usable in the current namespace\nwithout qualifying them.")
+	define(defaultEnv, "alias", l.alias, "([alias ns])", "Lets the current namespace refer to ns by alias, like alias/name.")
+	define(defaultEnv, "ns-name", l.nsName, "([ns])", "Returns the name of the namespace as a symbol.")
+	define(defaultEnv, "all-ns", l.allNs, "([])", "Returns a list of all of the namespaces.")
+	define(defaultEnv, "ns-publics", l.nsPublics, "([ns])", "Returns a map of the public definitions in the namespace.")
 	defaultEnv.Set("*host-language*", "wot")
-	ev(defaultEnv, "(def! not (fn* (a) (if a false true)))")
-	ev(defaultEnv, `(def! load-file (fn* (f) (eval (read-string (str "(do " (slurp f) ")")))))`)
-	ev(defaultEnv, `(defmacro! cond (fn* (& xs) (if (> (count xs) 0) (list 'if (first xs) (if (> (count xs) 1) (nth xs 1) (throw "odd number of forms to cond")) (cons 'cond (rest (rest xs)))))))`)
-	ev(defaultEnv, "(def! *gensym-counter* (atom 0))")
-	ev(defaultEnv, "(def! gensym (fn* [] (symbol (str \"G__\" (swap! *gensym-counter* (fn* [x] (+ 1 x)))))))")
-	ev(defaultEnv, "(defmacro! or (fn* (& xs) (if (empty? xs) nil (if (= 1 (count xs)) (first xs) (let* (condvar (gensym)) `(let* (~condvar ~(first xs)) (if ~condvar ~condvar (or ~@(rest xs)))))))))")
+	defaultEnv.Set("*file*", nil)
+	defaultEnv.Set("*in*", newStdin())
+	defaultEnv.Set("*print-right-margin*", float64(defaultRightMargin))
+	defaultEnv.Set("*print-length*", nil)
+	defaultEnv.Set("*print-level*", nil)
+	defaultEnv.Set("*print-meta*", false)
+	defaultEnv.Set("*load-path*", types.NewVect())
 	return defaultEnv
 }
 
+func define(e *env.Env, name types.Symbol, fn func(types.Env, []types.Base) (types.Base, error), arglists, doc string) {
+	stdFn := types.Func(fn)
+	documentBuiltin(stdFn, arglists, doc)
+	e.Set(name, stdFn)
+}
+
 func ev(e *env.Env, source string) {
 	ast, parseErr := readString(e, []types.Base{source})
 	if parseErr != nil {
@@ -37,11 +115,13 @@ func ev(e *env.Env, source string) {
 	}
 }
 
-func eval(defaultEnv types.Env) *types.StdFunc {
-	return types.Func(func(e types.Env, a []types.Base) (types.Base, error) {
+func eval(defaultEnv *env.Env) *types.StdFunc {
+	fn := types.Func(func(e types.Env, a []types.Base) (types.Base, error) {
 		if len(a) < 1 {
 			return nil, nil
 		}
-		return runtime.Eval(defaultEnv, a[0])
+		return runtime.Eval(defaultEnv.Current(), a[0])
 	})
+	documentBuiltin(fn, "([form])", "Evaluates the form in the current namespace and returns the result.")
+	return fn
 }
@@ -0,0 +1,305 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/tanema/mal/src/env"
+	"github.com/tanema/mal/src/types"
+)
+
+// nsPath maps a namespace name to the file that defines it, my.app is found in
+// my/app.mal
+func nsPath(name types.Symbol) string {
+	return filepath.FromSlash(strings.Replace(string(name), ".", "/", -1)) + ".mal"
+}
+
+// nsRoot finds the directory that namespaces are loaded relative to. If the
+// current file defines the current namespace, like src/my/app.mal defining my.app,
+// then it is the directory that contains the namespace path, src. Otherwise it
+// is the directory of the current file.
+func (l *loader) nsRoot() string {
+	current, ok := lookup(l.env, "*file*").(string)
+	if !ok {
+		return ""
+	}
+	return nsRoot(current, l.registry().Current().Name)
+}
+
+func nsRoot(file string, name types.Symbol) string {
+	if isLibPath(file) {
+		rel, nsFile := strings.TrimPrefix(file, libPrefix), filepath.ToSlash(nsPath(name))
+		if rel == nsFile {
+			return libPrefix
+		} else if strings.HasSuffix(rel, "/"+nsFile) {
+			return libPrefix + strings.TrimSuffix(rel, "/"+nsFile)
+		}
+		return filepath.Dir(file)
+	}
+	suffix := string(filepath.Separator) + nsPath(name)
+	if strings.HasSuffix(file, suffix) {
+		return strings.TrimSuffix(file, suffix)
+	}
+	return filepath.Dir(file)
+}
+
+func (l *loader) registry() *env.Registry {
+	return l.env.Namespace().Registry()
+}
+
+// loadNamespace returns the namespace with the name, loading it from the file
+// that the name maps to if it has not been loaded yet. Requiring a namespace
+// whose file is still loading is an error, since it would only be half defined.
+func (l *loader) loadNamespace(name types.Symbol) (*env.Namespace, error) {
+	for i, loading := range l.namespaces {
+		if loading == name {
+			return nil, fmt.Errorf("require cycle detected: %v", requireChain(l.namespaces[i:], name))
+		}
+	}
+	if ns := l.registry().Find(name); ns != nil {
+		return ns, nil
+	}
+	path, err := resolvePath(l.env, nsPath(name), l.nsRoot())
+	if err != nil {
+		return nil, fmt.Errorf("could not load namespace %v: %v", name, err)
+	}
+	if _, err := l.load(path); err != nil {
+		return nil, err
+	}
+	ns := l.registry().Find(name)
+	if ns == nil {
+		return nil, fmt.Errorf("namespace %v was not defined in %v", name, path)
+	}
+	return ns, nil
+}
+
+// requireChain describes a require cycle, like a -> b -> a, leaving out the
+// files that did not declare a namespace
+func requireChain(namespaces []types.Symbol, name types.Symbol) string {
+	chain := []string{}
+	for _, ns := range append(namespaces, name) {
+		if ns != "" {
+			chain = append(chain, string(ns))
+		}
+	}
+	return strings.Join(chain, " -> ")
+}
+
+func (l *loader) findNamespace(val types.Base) (*env.Namespace, error) {
+	switch tval := val.(type) {
+	case *env.Namespace:
+		return tval, nil
+	case types.Symbol:
+		if ns := l.registry().Find(tval); ns != nil {
+			return ns, nil
+		}
+		return nil, fmt.Errorf("no namespace '%v' found", tval)
+	default:
+		return nil, errors.New("expected a namespace or a symbol")
+	}
+}
+
+// requireSpec loads a namespace and makes it available in ns. The spec is either
+// the name of the namespace or a vector like [my.util :as u :refer [helper]].
+func (l *loader) requireSpec(ns *env.Namespace, spec types.Base) error {
+	var name types.Symbol
+	var opts []types.Base
+	switch tspec := spec.(type) {
+	case types.Symbol:
+		name = tspec
+	case types.Collection:
+		data := tspec.Data()
+		if len(data) == 0 {
+			return errors.New("empty require spec")
+		}
+		sym, ok := data[0].(types.Symbol)
+		if !ok {
+			return errors.New("require spec must start with a namespace name")
+		}
+		name, opts = sym, data[1:]
+	default:
+		return errors.New("invalid require spec")
+	}
+	target, err := l.loadNamespace(name)
+	if err != nil {
+		return err
+	}
+	if len(opts)%2 == 1 {
+		return fmt.Errorf("odd number of options in require spec for %v", name)
+	}
+	for i := 0; i < len(opts); i += 2 {
+		switch opts[i] {
+		case types.Keyword("as"):
+			alias, ok := opts[i+1].(types.Symbol)
+			if !ok {
+				return errors.New(":as expects a symbol")
+			}
+			ns.Alias(alias, target)
+		case types.Keyword("refer"):
+			if opts[i+1] == types.Keyword("all") {
+				err = ns.Refer(target)
+			} else if names, ok := opts[i+1].(types.Collection); ok {
+				err = ns.Refer(target, symbols(names.Data())...)
+			} else {
+				err = errors.New(":refer expects :all or a vector of symbols")
+			}
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown require option %v", opts[i])
+		}
+	}
+	return nil
+}
+
+func symbols(forms []types.Base) []types.Symbol {
+	syms := []types.Symbol{}
+	for _, form := range forms {
+		if sym, ok := form.(types.Symbol); ok {
+			syms = append(syms, sym)
+		}
+	}
+	return syms
+}
+
+func (l *loader) require(e types.Env, a []types.Base) (types.Base, error) {
+	current := l.registry().Current()
+	for _, spec := range a {
+		if err := l.requireSpec(current, spec); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// declareNs is used by the ns macro to switch to a namespace and process its
+// docstring and (:require ...) clauses
+func (l *loader) declareNs(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 2); err != nil {
+		return nil, err
+	}
+	name, ok := a[0].(types.Symbol)
+	if !ok {
+		return nil, errors.New("ns expects a symbol for the namespace name")
+	}
+	clauses, _ := a[1].(types.Collection)
+	ns := l.registry().Create(name)
+	l.registry().SetCurrent(ns)
+	if len(l.namespaces) > 0 {
+		l.namespaces[len(l.namespaces)-1] = name
+	}
+	if clauses == nil {
+		return nil, nil
+	}
+	for _, clause := range clauses.Data() {
+		if doc, isDoc := clause.(string); isDoc {
+			ns.Doc = doc
+			continue
+		}
+		col, ok := clause.(types.Collection)
+		if !ok || len(col.Data()) == 0 {
+			return nil, fmt.Errorf("invalid ns clause %v", clause)
+		}
+		data := col.Data()
+		switch data[0] {
+		case types.Keyword("require"):
+			for _, spec := range data[1:] {
+				if err := l.requireSpec(ns, spec); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unsupported ns clause %v", data[0])
+		}
+	}
+	return nil, nil
+}
+
+func (l *loader) inNs(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	name, ok := a[0].(types.Symbol)
+	if !ok {
+		return nil, errors.New("in-ns expects a symbol")
+	}
+	ns := l.registry().Create(name)
+	l.registry().SetCurrent(ns)
+	return ns, nil
+}
+
+func (l *loader) refer(e types.Env, a []types.Base) (types.Base, error) {
+	if len(a) != 1 && len(a) != 3 {
+		return nil, errors.New("wrong number of arguments")
+	}
+	target, err := l.findNamespace(a[0])
+	if err != nil {
+		return nil, err
+	}
+	names := []types.Symbol{}
+	if len(a) == 3 {
+		col, ok := a[2].(types.Collection)
+		if a[1] != types.Keyword("only") || !ok {
+			return nil, errors.New("refer expects :only and a vector of symbols")
+		}
+		names = symbols(col.Data())
+		if len(names) == 0 {
+			return nil, nil
+		}
+	}
+	return nil, l.registry().Current().Refer(target, names...)
+}
+
+func (l *loader) alias(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 2); err != nil {
+		return nil, err
+	}
+	alias, ok := a[0].(types.Symbol)
+	if !ok {
+		return nil, errors.New("alias expects a symbol")
+	}
+	target, err := l.findNamespace(a[1])
+	if err != nil {
+		return nil, err
+	}
+	l.registry().Current().Alias(alias, target)
+	return nil, nil
+}
+
+func (l *loader) nsName(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	ns, err := l.findNamespace(a[0])
+	if err != nil {
+		return nil, err
+	}
+	return ns.Name, nil
+}
+
+func (l *loader) allNs(e types.Env, a []types.Base) (types.Base, error) {
+	all := []types.Base{}
+	for _, ns := range l.registry().All() {
+		all = append(all, ns)
+	}
+	return types.NewList(all...), nil
+}
+
+func (l *loader) nsPublics(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	ns, err := l.findNamespace(a[0])
+	if err != nil {
+		return nil, err
+	}
+	publics := []types.Base{}
+	for _, name := range ns.Publics() {
+		val, _ := ns.Env().Get(name)
+		publics = append(publics, name, val)
+	}
+	return types.NewHashmap(publics)
+}
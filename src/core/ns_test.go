@@ -0,0 +1,51 @@
+package core
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tanema/mal/src/reader"
+	"github.com/tanema/mal/src/runtime"
+	"github.com/tanema/mal/src/types"
+)
+
+// TestRequireCycle checks that namespaces that require each other are an
+// error rather than one of them getting the other half loaded
+func TestRequireCycle(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"a.mal":    `(ns a (:require b)) (def! x 1)`,
+		"b.mal":    `(ns b (:require a)) (def! y a/x)`,
+		"self.mal": `(ns self (:require self))`,
+		"main.mal": `(ns main (:require c))`,
+		"c.mal":    `(ns c (:require main))`,
+		"d.mal":    `(ns d (:require e)) (def! x e/y)`,
+		"e.mal":    `(ns e) (def! y 2)`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cases := map[string]string{
+		"(require 'a)":                       "require cycle detected: a -> b -> a",
+		"(require 'self)":                    "require cycle detected: self -> self",
+		`(load-file "` + dir + `/main.mal")`: "require cycle detected: main -> c -> main",
+		"(require 'd)":                       "",
+	}
+	for src, expected := range cases {
+		defaultEnv := DefaultNamespace()
+		defaultEnv.Set("*load-path*", types.NewVect(dir))
+		form, err := reader.ReadString(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = runtime.Eval(defaultEnv.Current(), form)
+		if expected == "" && err != nil {
+			t.Errorf("%v returned %v", src, err)
+		} else if expected != "" && (err == nil || !strings.Contains(err.Error(), expected)) {
+			t.Errorf("%v returned %v, expected %v", src, err, expected)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/tanema/mal/src/printer"
+	"github.com/tanema/mal/src/types"
+)
+
+// defaultRightMargin is the width that values are pretty printed in when
+// *print-right-margin* is not set to a width
+const defaultRightMargin = 72
+
+// RightMargin is the width that values are pretty printed in, which is set by
+// *print-right-margin*
+func RightMargin(e types.Env) int {
+	if margin := printLimit(lookup(e, "*print-right-margin*")); margin != nil && *margin > 0 {
+		return *margin
+	}
+	return defaultRightMargin
+}
+
+// PrintOptions are the limits that values are printed within, which are set by
+// *print-length*, *print-level* and *print-meta*
+func PrintOptions(e types.Env) printer.Options {
+	return printer.Options{
+		Length: printLimit(lookup(e, "*print-length*")),
+		Level:  printLimit(lookup(e, "*print-level*")),
+		Meta:   truthy(lookup(e, "*print-meta*")),
+	}
+}
+
+// printLimit is the limit that a setting like *print-length* is set to, or nil
+// for no limit if it is nil or not a number. A limit of 0 prints only ... or #.
+func printLimit(val types.Base) *int {
+	limit, ok := val.(float64)
+	if !ok || math.IsNaN(limit) {
+		return nil
+	}
+	return printer.Limit(int(math.Max(0, math.Min(limit, math.MaxInt32))))
+}
+
+func pprint(e types.Env, a []types.Base) (types.Base, error) {
+	out, err := pprintStr(e, a)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println(out)
+	return nil, nil
+}
+
+func pprintStr(e types.Env, a []types.Base) (types.Base, error) {
+	if len(a) < 1 {
+		return nil, errors.New("wrong number of arguments")
+	}
+	values, err := keywordOpts(a[1:], "code")
+	if err != nil {
+		return nil, err
+	}
+	opts := PrintOptions(e)
+	if err := chargePrint(e, opts, a[:1]); err != nil {
+		return nil, err
+	}
+	return opts.Pretty(a[0], RightMargin(e), truthy(values["code"])), nil
+}
@@ -0,0 +1,23 @@
+package core
+
+import "testing"
+
+// TestPprint checks that pprint-str breaks values that do not fit in
+// *print-right-margin* over several lines
+func TestPprint(t *testing.T) {
+	defaultEnv := DefaultNamespace()
+	cases := []struct {
+		src      string
+		expected string
+	}{
+		{`(pprint-str {:a 1})`, `"{:a 1}"`},
+		{`(def! *print-right-margin* 10) (pprint-str [1 2 3 4 5 6])`, `"[1\n 2\n 3\n 4\n 5\n 6]"`},
+		{`(pprint-str '(defn f [x] (let* [y (inc x)] (println y) y)) :code true)`, `"(defn f\n  [x]\n  (let* [y (inc x)]\n    (println y)\n    y))"`},
+		{`(def! *print-right-margin* nil) (pprint-str [1 2 3 4 5 6])`, `"[1 2 3 4 5 6]"`},
+	}
+	for _, c := range cases {
+		if val, err := evalPrint(defaultEnv, c.src); err != nil || val != c.expected {
+			t.Errorf("%v returned %v, %v, expected %v", c.src, val, err, c.expected)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package core
+
+import "testing"
+
+// TestPrintVars checks that printing honors *print-length*, *print-level* and
+// *print-meta* and stops at atoms that hold themselves
+func TestPrintVars(t *testing.T) {
+	defaultEnv := DefaultNamespace()
+	cases := []struct {
+		src      string
+		expected string
+	}{
+		{`(def! *print-length* 2) (pr-str [1 [2 3 4] 5] {:a 1 :b 2 :c 3})`, `"[1 [2 3 ...] ...] {:a 1 :b 2 ...}"`},
+		{`(pprint-str [1 2 3 4])`, `"[1 2 ...]"`},
+		{`(str [1 2 3])`, `"[1 2 3]"`},
+		{`(def! *print-length* 0) (pr-str [1] 1)`, `"[...] 1"`},
+		{`(def! *print-length* nil) (def! *print-level* 1) (pr-str [1 [2 [3]]] {:a {:b 1}})`, `"[1 #] {:a #}"`},
+		{`(def! *print-level* 0) (pr-str [1] 1)`, `"# 1"`},
+		{`(def! *print-level* nil) (def! *print-meta* true) (pr-str (with-meta [1] {:m 1}))`, `"^{:m 1} [1]"`},
+		{`(def! *print-meta* false) (pr-str (with-meta [1] {:m 1}))`, `"[1]"`},
+		{`(let* [a (atom 1)] (do (reset! a {:self a}) (pr-str a)))`, `"(atom {:self (atom ...)})"`},
+	}
+	for _, c := range cases {
+		if val, err := evalPrint(defaultEnv, c.src); err != nil || val != c.expected {
+			t.Errorf("%v returned %v, %v, expected %v", c.src, val, err, c.expected)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package core
+
+import (
+	"errors"
+
+	"github.com/tanema/mal/src/types"
+)
+
+func rePattern(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	switch pattern := a[0].(type) {
+	case *types.Regex:
+		return pattern, nil
+	case string:
+		return types.NewRegex(pattern)
+	default:
+		return nil, errors.New("re-pattern expects a string")
+	}
+}
+
+func reMatcher(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 2); err != nil {
+		return nil, err
+	}
+	re, s, err := regexArgs(a)
+	if err != nil {
+		return nil, err
+	}
+	return types.NewMatcher(re, s), nil
+}
+
+func reFind(e types.Env, a []types.Base) (types.Base, error) {
+	if len(a) == 1 {
+		m, ok := a[0].(*types.Matcher)
+		if !ok {
+			return nil, errors.New("re-find expects a matcher")
+		}
+		return m.Find(), nil
+	} else if err := assertArgNum(a, 2); err != nil {
+		return nil, err
+	}
+	re, s, err := regexArgs(a)
+	if err != nil {
+		return nil, err
+	}
+	return re.Match(s, re.FindStringSubmatchIndex(s)), nil
+}
+
+func reMatches(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 2); err != nil {
+		return nil, err
+	}
+	re, s, err := regexArgs(a)
+	if err != nil {
+		return nil, err
+	}
+	anchored, err := types.NewRegex(`\A(?:` + re.String() + `)\z`)
+	if err != nil {
+		return nil, err
+	}
+	return re.Match(s, anchored.FindStringSubmatchIndex(s)), nil
+}
+
+func reSeq(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 2); err != nil {
+		return nil, err
+	}
+	re, s, err := regexArgs(a)
+	if err != nil {
+		return nil, err
+	}
+	locs := re.FindAllStringSubmatchIndex(s, -1)
+	if len(locs) == 0 {
+		return nil, nil
+	}
+	matches := make([]types.Base, len(locs))
+	for i, loc := range locs {
+		matches[i] = re.Match(s, loc)
+	}
+	return types.NewList(matches...), nil
+}
+
+func reGroups(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	m, ok := a[0].(*types.Matcher)
+	if !ok {
+		return nil, errors.New("re-groups expects a matcher")
+	}
+	return m.Groups()
+}
+
+func isregex(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	_, ok := a[0].(*types.Regex)
+	return ok, nil
+}
+
+func regexArgs(a []types.Base) (*types.Regex, string, error) {
+	re, ok := a[0].(*types.Regex)
+	if !ok {
+		return nil, "", errors.New("expected a regex")
+	}
+	s, err := stringArg(a, 1)
+	return re, s, err
+}
@@ -0,0 +1,148 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/tanema/mal/src/types"
+)
+
+// compareFn is compare, the comparator of maps made with sorted-map
+var compareFn = types.Func(compareValues)
+
+func compareValues(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 2); err != nil {
+		return nil, err
+	}
+	return float64(sign(types.Compare(a[0], a[1]))), nil
+}
+
+func sortedMap(e types.Env, a []types.Base) (types.Base, error) {
+	return newSortedMap(e, compareFn, a)
+}
+
+func sortedMapBy(e types.Env, a []types.Base) (types.Base, error) {
+	if len(a) < 1 {
+		return nil, errors.New("wrong number of arguments")
+	}
+	return newSortedMap(e, a[0], a[1:])
+}
+
+func newSortedMap(e types.Env, comparator types.Base, pairs []types.Base) (*types.Hashmap, error) {
+	hm, err := types.NewHashmap(pairs)
+	if err != nil {
+		return nil, err
+	}
+	return hm, sortKeys(e, hm, comparator)
+}
+
+// sortKeys makes the map a sorted map ordered by the comparator
+func sortKeys(e types.Env, hm *types.Hashmap, comparator types.Base) error {
+	keys := make([]types.Base, 0, len(hm.Forms))
+	for key := range hm.Forms {
+		keys = append(keys, key)
+	}
+	var err error
+	sort.SliceStable(keys, func(i, j int) bool {
+		if err != nil {
+			return false
+		}
+		var order int
+		order, err = compareWith(e, comparator, keys[i], keys[j])
+		return order < 0
+	})
+	hm.Comparator, hm.SortedKeys = comparator, keys
+	return err
+}
+
+// compareWith calls the comparator of a sorted map, which returns a number
+// like compare or true if a comes before b, like <
+func compareWith(e types.Env, comparator, a, b types.Base) (int, error) {
+	if comparator == compareFn {
+		return types.Compare(a, b), nil
+	}
+	result, err := types.CallFunc(e, comparator, []types.Base{a, b})
+	if err != nil {
+		return 0, err
+	}
+	switch tresult := result.(type) {
+	case float64:
+		return types.Compare(tresult, 0.0), nil
+	case bool:
+		if tresult {
+			return -1, nil
+		}
+		after, err := types.CallFunc(e, comparator, []types.Base{b, a})
+		if truthy(after) {
+			return 1, err
+		}
+		return 0, err
+	default:
+		return 0, errors.New("a comparator must return a number or a boolean")
+	}
+}
+
+func sign(n int) int {
+	if n < 0 {
+		return -1
+	} else if n > 0 {
+		return 1
+	}
+	return 0
+}
+
+func issorted(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	hm, ok := a[0].(*types.Hashmap)
+	return ok && hm.Comparator != nil, nil
+}
+
+func subseq(e types.Env, a []types.Base) (types.Base, error) {
+	return sortedEntries(e, a, "subseq", false)
+}
+
+func rsubseq(e types.Env, a []types.Base) (types.Base, error) {
+	return sortedEntries(e, a, "rsubseq", true)
+}
+
+// sortedEntries returns the [key value] entries of a sorted map whose keys
+// pass the tests, like (subseq m >= 2 < 5). Each test is called with the
+// comparator's order of the key and the test key, and 0.
+func sortedEntries(e types.Env, a []types.Base, name string, reverse bool) (types.Base, error) {
+	if len(a) != 3 && len(a) != 5 {
+		return nil, errors.New("wrong number of arguments")
+	}
+	hm, ok := a[0].(*types.Hashmap)
+	if !ok || hm.Comparator == nil {
+		return nil, fmt.Errorf("%v expects a sorted map", name)
+	}
+	entries := []types.Base{}
+	for i := range hm.SortedKeys {
+		key := hm.SortedKeys[i]
+		if reverse {
+			key = hm.SortedKeys[len(hm.SortedKeys)-1-i]
+		}
+		matches := true
+		for j := 1; j < len(a) && matches; j += 2 {
+			order, err := compareWith(e, hm.Comparator, key, a[j+1])
+			if err != nil {
+				return nil, err
+			}
+			result, err := types.CallFunc(e, a[j], []types.Base{float64(order), 0.0})
+			if err != nil {
+				return nil, err
+			}
+			matches = truthy(result)
+		}
+		if matches {
+			entries = append(entries, types.NewVect(key, hm.Forms[key]))
+		}
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	return types.NewList(entries...), nil
+}
@@ -0,0 +1,27 @@
+package core
+
+import "testing"
+
+// TestSortedMaps checks the order that maps and sorted maps keep their keys in
+// and the entries that subseq and rsubseq return
+func TestSortedMaps(t *testing.T) {
+	defaultEnv := DefaultNamespace()
+	cases := []struct {
+		src      string
+		expected string
+	}{
+		{`{10 :c 2 :b 1 :a}`, `{1 :a 2 :b 10 :c}`},
+		{`[(keys {:b 1 :a 2}) (vals {:b 1 :a 2})]`, `[(:a :b) (2 1)]`},
+		{`(sorted-map-by > 1 :a 3 :c 2 :b)`, `{3 :c 2 :b 1 :a}`},
+		{`(keys (assoc (sorted-map-by > 1 :a) 5 :e 3 :c))`, `(5 3 1)`},
+		{`(dissoc (sorted-map 1 :a 2 :b) 1)`, `{2 :b}`},
+		{`(subseq (sorted-map 1 :a 2 :b 3 :c 4 :d) > 1 <= 3)`, `([2 :b] [3 :c])`},
+		{`(rsubseq (sorted-map 1 :a 2 :b 3 :c) < 3)`, `([2 :b] [1 :a])`},
+		{`[(compare 1 2) (compare "b" "a") (compare [1 2] [1 2])]`, `[-1 1 0]`},
+	}
+	for _, c := range cases {
+		if val, err := evalPrint(defaultEnv, c.src); err != nil || val != c.expected {
+			t.Errorf("%v returned %v, %v, expected %v", c.src, val, err, c.expected)
+		}
+	}
+}
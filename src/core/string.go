@@ -0,0 +1,307 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/tanema/mal/src/printer"
+	"github.com/tanema/mal/src/types"
+)
+
+// StringNs is the namespace of the string functions, like string/join. Indexes
+// and lengths count characters rather than bytes.
+const StringNs = "string"
+
+var stringNamespace = map[types.Symbol]*types.StdFunc{
+	"subs":         types.Func(subs),
+	"split":        types.Func(split),
+	"join":         types.Func(join),
+	"trim":         types.Func(trim),
+	"triml":        types.Func(triml),
+	"trimr":        types.Func(trimr),
+	"upper-case":   types.Func(upperCase),
+	"lower-case":   types.Func(lowerCase),
+	"replace":      types.Func(replace),
+	"starts-with?": types.Func(startsWith),
+	"ends-with?":   types.Func(endsWith),
+	"includes?":    types.Func(includes),
+	"index-of":     types.Func(indexOf),
+	"blank?":       types.Func(isblank),
+	"split-lines":  types.Func(splitLines),
+	"reverse":      types.Func(reverse),
+}
+
+var stringDocs = map[types.Symbol][2]string{
+	"subs":         {"([s start] [s start end])", "Returns the part of s from the character at start up to, but not\nincluding, the character at end or the end of s."},
+	"split":        {"([s sep] [s sep limit])", "Splits s on each occurrence of sep and returns a vector of the parts. At\nmost limit parts are returned when it is given."},
+	"join":         {"([coll] [sep coll])", "Returns the items in coll printed and joined together, with sep between\neach of them."},
+	"trim":         {"([s])", "Removes whitespace from both ends of s."},
+	"triml":        {"([s])", "Removes whitespace from the start of s."},
+	"trimr":        {"([s])", "Removes whitespace from the end of s."},
+	"upper-case":   {"([s])", "Returns s with every character in upper case."},
+	"lower-case":   {"([s])", "Returns s with every character in lower case."},
+	"replace":      {"([s match replacement])", "Replaces every occurrence of match in s with replacement. When match is\na regex, $1 or ${name} in the replacement is replaced with the group."},
+	"starts-with?": {"([s prefix])", "Returns true if s starts with prefix."},
+	"ends-with?":   {"([s suffix])", "Returns true if s ends with suffix."},
+	"includes?":    {"([s substr])", "Returns true if s contains substr."},
+	"index-of":     {"([s value] [s value from])", "Returns the index of the first character of value in s, looking from\nthe index from, or nil if it is not found."},
+	"blank?":       {"([s])", "Returns true if s is nil, empty or only whitespace."},
+	"split-lines":  {"([s])", "Splits s on \\n or \\r\\n and returns a vector of the lines."},
+	"reverse":      {"([s])", "Returns s with its characters in reverse order."},
+}
+
+func init() {
+	documentAll(stringNamespace, stringDocs)
+}
+
+func subs(e types.Env, a []types.Base) (types.Base, error) {
+	if len(a) != 2 && len(a) != 3 {
+		return nil, errors.New("wrong number of arguments")
+	}
+	s, err := stringArg(a, 0)
+	if err != nil {
+		return nil, err
+	}
+	runes := []rune(s)
+	start, err := indexArg(a, 1)
+	if err != nil {
+		return nil, err
+	}
+	end := len(runes)
+	if len(a) == 3 {
+		if end, err = indexArg(a, 2); err != nil {
+			return nil, err
+		}
+	}
+	if start > end || end > len(runes) {
+		return nil, fmt.Errorf("string index out of range %v to %v", start, end)
+	}
+	return string(runes[start:end]), nil
+}
+
+func split(e types.Env, a []types.Base) (types.Base, error) {
+	if len(a) != 2 && len(a) != 3 {
+		return nil, errors.New("wrong number of arguments")
+	}
+	s, sep, err := stringArgs(a)
+	if err != nil {
+		return nil, err
+	}
+	limit := -1
+	if len(a) == 3 {
+		if limit, err = indexArg(a, 2); err != nil {
+			return nil, err
+		} else if limit == 0 {
+			limit = -1
+		}
+	}
+	return stringVect(strings.SplitN(s, sep, limit)), nil
+}
+
+func join(e types.Env, a []types.Base) (types.Base, error) {
+	if len(a) != 1 && len(a) != 2 {
+		return nil, errors.New("wrong number of arguments")
+	}
+	sep := ""
+	if len(a) == 2 {
+		var err error
+		if sep, err = stringArg(a, 0); err != nil {
+			return nil, err
+		}
+	}
+	switch coll := a[len(a)-1].(type) {
+	case types.Collection:
+		if err := chargePrint(e, printer.Options{}, coll.Data()); err != nil {
+			return nil, err
+		}
+		return printer.List(coll.Data(), false, "", "", sep), nil
+	case nil:
+		return "", nil
+	default:
+		return nil, errors.New("join expects a collection")
+	}
+}
+
+func trim(e types.Env, a []types.Base) (types.Base, error) {
+	return mapString(a, strings.TrimSpace)
+}
+
+func triml(e types.Env, a []types.Base) (types.Base, error) {
+	return mapString(a, func(s string) string { return strings.TrimLeftFunc(s, unicode.IsSpace) })
+}
+
+func trimr(e types.Env, a []types.Base) (types.Base, error) {
+	return mapString(a, func(s string) string { return strings.TrimRightFunc(s, unicode.IsSpace) })
+}
+
+func upperCase(e types.Env, a []types.Base) (types.Base, error) {
+	return mapString(a, strings.ToUpper)
+}
+
+func lowerCase(e types.Env, a []types.Base) (types.Base, error) {
+	return mapString(a, strings.ToLower)
+}
+
+func reverse(e types.Env, a []types.Base) (types.Base, error) {
+	return mapString(a, func(s string) string {
+		runes := []rune(s)
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		return string(runes)
+	})
+}
+
+func replace(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 3); err != nil {
+		return nil, err
+	}
+	s, err := stringArg(a, 0)
+	if err != nil {
+		return nil, err
+	}
+	replacement, err := stringArg(a, 2)
+	if err != nil {
+		return nil, err
+	}
+	if re, isRegex := a[1].(*types.Regex); isRegex {
+		return re.ReplaceAllString(s, replacement), nil
+	}
+	match, err := stringArg(a, 1)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Replace(s, match, replacement, -1), nil
+}
+
+func startsWith(e types.Env, a []types.Base) (types.Base, error) {
+	return compareStrings(a, strings.HasPrefix)
+}
+
+func endsWith(e types.Env, a []types.Base) (types.Base, error) {
+	return compareStrings(a, strings.HasSuffix)
+}
+
+func includes(e types.Env, a []types.Base) (types.Base, error) {
+	return compareStrings(a, strings.Contains)
+}
+
+func indexOf(e types.Env, a []types.Base) (types.Base, error) {
+	if len(a) != 2 && len(a) != 3 {
+		return nil, errors.New("wrong number of arguments")
+	}
+	s, value, err := stringArgs(a)
+	if err != nil {
+		return nil, err
+	}
+	from := 0
+	if len(a) == 3 {
+		if from, err = indexArg(a, 2); err != nil {
+			return nil, err
+		}
+	}
+	runes := []rune(s)
+	if from > len(runes) {
+		return nil, nil
+	}
+	rest := string(runes[from:])
+	idx := strings.Index(rest, value)
+	if idx < 0 {
+		return nil, nil
+	}
+	return float64(from + utf8.RuneCountInString(rest[:idx])), nil
+}
+
+func isblank(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	} else if a[0] == nil {
+		return true, nil
+	}
+	s, err := stringArg(a, 0)
+	if err != nil {
+		return nil, err
+	}
+	return strings.TrimSpace(s) == "", nil
+}
+
+func splitLines(e types.Env, a []types.Base) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	s, err := stringArg(a, 0)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return stringVect(lines), nil
+}
+
+// mapString calls fn on the only argument, which has to be a string
+func mapString(a []types.Base, fn func(string) string) (types.Base, error) {
+	if err := assertArgNum(a, 1); err != nil {
+		return nil, err
+	}
+	s, err := stringArg(a, 0)
+	if err != nil {
+		return nil, err
+	}
+	return fn(s), nil
+}
+
+// compareStrings calls fn with the two arguments, which have to be strings
+func compareStrings(a []types.Base, fn func(string, string) bool) (types.Base, error) {
+	if err := assertArgNum(a, 2); err != nil {
+		return nil, err
+	}
+	s, substr, err := stringArgs(a)
+	if err != nil {
+		return nil, err
+	}
+	return fn(s, substr), nil
+}
+
+func stringArgs(a []types.Base) (string, string, error) {
+	x, err := stringArg(a, 0)
+	if err != nil {
+		return "", "", err
+	}
+	y, err := stringArg(a, 1)
+	return x, y, err
+}
+
+func stringArg(a []types.Base, i int) (string, error) {
+	s, ok := a[i].(string)
+	if !ok {
+		return "", errors.New("expected a string")
+	}
+	return s, nil
+}
+
+// indexArg returns the argument as an int if it is a whole number that is not
+// negative
+func indexArg(a []types.Base, i int) (int, error) {
+	n, ok := a[i].(float64)
+	if !ok || n < 0 || n > math.MaxInt32 || n != math.Trunc(n) {
+		return 0, errors.New("expected a whole number that is not negative")
+	}
+	return int(n), nil
+}
+
+func stringVect(strs []string) *types.Vector {
+	forms := make([]types.Base, len(strs))
+	for i, s := range strs {
+		forms[i] = s
+	}
+	return types.NewVect(forms...)
+}
@@ -8,27 +8,49 @@ import (
 
 // Env captures all definitions and their values
 type Env struct {
-	data  map[string]types.Base
-	outer types.Env
+	data     map[string]types.Base
+	outer    types.Env
+	ns       *Namespace
+	registry *Registry
 }
 
 // New creates a new env, binds and exprs allow for parameter binding
 func New(outer types.Env, binds, exprs []types.Base) (*Env, error) {
 	env := &Env{data: map[string]types.Base{}, outer: outer}
+	if outerEnv, ok := outer.(*Env); ok {
+		env.registry = outerEnv.registry
+	}
 	for i, bind := range binds {
 		key, ok := bind.(types.Symbol)
 		if !ok {
 			return nil, fmt.Errorf("non-symbol bind value")
 		}
 		if key == "&" {
-			env.Set(binds[i+1].(types.Symbol), types.NewList(exprs[i:]...))
+			if err := env.bindRest(binds[i+1:], exprs[i:]); err != nil {
+				return nil, err
+			}
 			break
+		} else if i >= len(exprs) {
+			return nil, fmt.Errorf("wrong number of arguments, expected %v but got %v", len(binds), len(exprs))
 		}
 		env.Set(key, exprs[i])
 	}
 	return env, nil
 }
 
+// bindRest binds the symbol after & to a list of the remaining arguments
+func (e *Env) bindRest(binds, exprs []types.Base) error {
+	if len(binds) == 0 {
+		return fmt.Errorf("expected a symbol after &")
+	}
+	key, ok := binds[0].(types.Symbol)
+	if !ok {
+		return fmt.Errorf("non-symbol bind value")
+	}
+	e.Set(key, types.NewList(exprs...))
+	return nil
+}
+
 // Child creates a new Env that inherits this one. By adding this method we can
 // pass around env without importing env
 func (e *Env) Child(binds, exprs []types.Base) (types.Env, error) {
@@ -37,10 +59,15 @@ func (e *Env) Child(binds, exprs []types.Base) (types.Env, error) {
 
 // Find will find the env with the definition available. It will return nil otherwise
 func (e *Env) Find(key types.Symbol) types.Env {
-	if _, ok := e.data[string(key)]; ok {
-		return e
-	} else if e.outer != nil {
-		return e.outer.Find(key)
+	for env := e; env != nil; env, _ = env.outer.(*Env) {
+		if _, ok := env.data[string(key)]; ok {
+			return env
+		} else if env.ns != nil {
+			if target, name, err := env.ns.find(key); err == nil {
+				return target.Find(name)
+			}
+			return nil
+		}
 	}
 	return nil
 }
@@ -50,11 +77,83 @@ func (e *Env) Set(key types.Symbol, value types.Base) {
 	e.data[string(key)] = value
 }
 
-// Get will retreive the value of a symbol recursively up the parentage of this env
+// SetPrivate marks a definition as private so that it can only be used from the
+// namespace that it is defined in. It has no effect outside of a namespace env.
+func (e *Env) SetPrivate(key types.Symbol) {
+	if e.ns != nil {
+		e.ns.private[key] = true
+	}
+}
+
+// Symbols will return every symbol defined in this env and the envs it inherits
+func (e *Env) Symbols() []types.Symbol {
+	symbols := []types.Symbol{}
+	for env := e; env != nil; env, _ = env.outer.(*Env) {
+		for key := range env.data {
+			symbols = append(symbols, types.Symbol(key))
+		}
+		if env.ns != nil {
+			symbols = append(symbols, env.ns.visible()...)
+		}
+	}
+	return symbols
+}
+
+// Get will retreive the value of a symbol recursively up the parentage of this
+// env. When the root of a namespace is reached, qualified symbols, referred
+// symbols and the core namespace are checked.
 func (e *Env) Get(key types.Symbol) (types.Base, error) {
-	env := e.Find(key)
-	if env == nil {
-		return nil, fmt.Errorf("'%v' not found", key)
+	for env := e; env != nil; env, _ = env.outer.(*Env) {
+		if val, ok := env.data[string(key)]; ok {
+			return val, nil
+		} else if env.ns != nil {
+			target, name, err := env.ns.find(key)
+			if err != nil {
+				return nil, err
+			}
+			return target.data[string(name)], nil
+		}
+	}
+	return nil, fmt.Errorf("'%v' not found", key)
+}
+
+// Namespace returns the namespace that this env belongs to, or nil if it is not
+// part of one
+func (e *Env) Namespace() *Namespace {
+	for env := e; env != nil; env, _ = env.outer.(*Env) {
+		if env.ns != nil {
+			return env.ns
+		}
+	}
+	return nil
+}
+
+// Registry returns the registry of the interpreter that this env belongs to, or
+// nil if it is not part of one
+func (e *Env) Registry() *Registry {
+	return e.registry
+}
+
+// Current returns the env of the namespace that is currently in use. It is the
+// env that top level forms should be evaluated in.
+func (e *Env) Current() *Env {
+	if ns := e.Namespace(); ns != nil {
+		return ns.registry.current.env
+	}
+	return e
+}
+
+// Outer returns the env that this env inherits, or nil if there is not one
+func (e *Env) Outer() *Env {
+	outer, _ := e.outer.(*Env)
+	return outer
+}
+
+// Definitions returns a copy of the definitions made directly in this env
+func (e *Env) Definitions() map[types.Symbol]types.Base {
+	defs := make(map[types.Symbol]types.Base, len(e.data))
+	for key, val := range e.data {
+		defs[types.Symbol(key)] = val
 	}
-	return env.(*Env).data[string(key)], nil
+	return defs
 }
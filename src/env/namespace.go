@@ -0,0 +1,242 @@
+package env
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/tanema/mal/src/types"
+)
+
+// Registry keeps track of every namespace in an interpreter and which one is
+// currently in use
+type Registry struct {
+	// AllowedPaths are the directories that the files the interpreter uses have
+	// to be inside of, with their links resolved, or nil if any file can be used
+	AllowedPaths []string
+	namespaces   map[types.Symbol]*Namespace
+	core         *Namespace
+	current      *Namespace
+	steps        int64
+	stepLimit    int64
+}
+
+// SetStepLimit limits the number of steps that evaluation in the interpreter can
+// take, or removes the limit when it is 0, and starts the count again
+func (r *Registry) SetStepLimit(limit int64) {
+	atomic.StoreInt64(&r.steps, 0)
+	atomic.StoreInt64(&r.stepLimit, limit)
+}
+
+// StepLimited checks if a step limit has been set
+func (r *Registry) StepLimited() bool {
+	return atomic.LoadInt64(&r.stepLimit) > 0
+}
+
+// Step counts n steps and returns false once they are past the step limit
+func (r *Registry) Step(n int64) bool {
+	limit := atomic.LoadInt64(&r.stepLimit)
+	return limit <= 0 || atomic.AddInt64(&r.steps, n) <= limit
+}
+
+// Namespace is a named set of definitions along with the aliases and referred
+// symbols that the code in the namespace can see. Any symbol that cannot be
+// found in the namespace is looked up in the core namespace.
+type Namespace struct {
+	Name     types.Symbol
+	Doc      string
+	env      *Env
+	registry *Registry
+	aliases  map[types.Symbol]*Namespace
+	refers   map[types.Symbol]*Namespace
+	private  map[types.Symbol]bool
+}
+
+// NewRegistry creates the core namespace of a new interpreter and returns its
+// env. The core namespace is the current namespace until another is entered.
+func NewRegistry(coreName types.Symbol) *Env {
+	registry := &Registry{namespaces: map[types.Symbol]*Namespace{}}
+	registry.core = registry.Create(coreName)
+	registry.SetCurrent(registry.core)
+	return registry.core.env
+}
+
+// Registry returns the registry this namespace belongs to
+func (ns *Namespace) Registry() *Registry { return ns.registry }
+
+// Env returns the root env of the namespace where its definitions are kept
+func (ns *Namespace) Env() *Env { return ns.env }
+
+func (ns *Namespace) String() string { return string(ns.Name) }
+
+// Create returns the namespace with the name, creating it if it does not exist
+func (r *Registry) Create(name types.Symbol) *Namespace {
+	if ns, ok := r.namespaces[name]; ok {
+		return ns
+	}
+	ns := &Namespace{
+		Name:     name,
+		registry: r,
+		aliases:  map[types.Symbol]*Namespace{},
+		refers:   map[types.Symbol]*Namespace{},
+		private:  map[types.Symbol]bool{},
+	}
+	ns.env = &Env{data: map[string]types.Base{}, ns: ns, registry: r}
+	r.namespaces[name] = ns
+	return ns
+}
+
+// Find returns the namespace with the name or nil if it does not exist
+func (r *Registry) Find(name types.Symbol) *Namespace {
+	return r.namespaces[name]
+}
+
+// All returns every namespace sorted by name
+func (r *Registry) All() []*Namespace {
+	all := make([]*Namespace, 0, len(r.namespaces))
+	for _, ns := range r.namespaces {
+		all = append(all, ns)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+// Core returns the namespace that every other namespace falls back to
+func (r *Registry) Core() *Namespace { return r.core }
+
+// Current returns the namespace that top level forms are evaluated in
+func (r *Registry) Current() *Namespace { return r.current }
+
+// SetCurrent changes the current namespace and binds it to *ns*
+func (r *Registry) SetCurrent(ns *Namespace) {
+	r.current = ns
+	r.core.env.Set("*ns*", ns)
+}
+
+// Alias lets the namespace refer to target with a shorter name, like u/helper
+func (ns *Namespace) Alias(alias types.Symbol, target *Namespace) {
+	ns.aliases[alias] = target
+}
+
+// Refer makes the public definitions of target usable without qualification. If
+// no names are given all of the public definitions are referred.
+func (ns *Namespace) Refer(target *Namespace, names ...types.Symbol) error {
+	if len(names) == 0 {
+		names = target.Publics()
+	}
+	for _, name := range names {
+		if _, ok := target.env.data[string(name)]; !ok {
+			return fmt.Errorf("'%v' does not exist in %v", name, target.Name)
+		} else if target.private[name] {
+			return fmt.Errorf("'%v' is private to %v", name, target.Name)
+		}
+		ns.refers[name] = target
+	}
+	return nil
+}
+
+// Publics returns the names of all of the definitions that are not private
+func (ns *Namespace) Publics() []types.Symbol {
+	names := []types.Symbol{}
+	for key := range ns.env.data {
+		if !ns.private[types.Symbol(key)] {
+			names = append(names, types.Symbol(key))
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+// Aliases returns a copy of the aliases that the namespace has for others
+func (ns *Namespace) Aliases() map[types.Symbol]*Namespace {
+	aliases := make(map[types.Symbol]*Namespace, len(ns.aliases))
+	for alias, target := range ns.aliases {
+		aliases[alias] = target
+	}
+	return aliases
+}
+
+// Refers returns a copy of the symbols referred into the namespace along with
+// the namespace that each one is from
+func (ns *Namespace) Refers() map[types.Symbol]*Namespace {
+	refers := make(map[types.Symbol]*Namespace, len(ns.refers))
+	for name, target := range ns.refers {
+		refers[name] = target
+	}
+	return refers
+}
+
+// Privates returns the names of the private definitions sorted by name
+func (ns *Namespace) Privates() []types.Symbol {
+	names := []types.Symbol{}
+	for name, private := range ns.private {
+		if private {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+// IsPrivate checks if the definition can only be used inside of this namespace
+func (ns *Namespace) IsPrivate(name types.Symbol) bool {
+	return ns.private[name]
+}
+
+// find works out which namespace env a symbol is defined in and the name that
+// it is defined with there
+func (ns *Namespace) find(key types.Symbol) (*Env, types.Symbol, error) {
+	if target, ok := ns.refers[key]; ok {
+		return target.env, key, nil
+	}
+	if core := ns.registry.core; core != ns {
+		if _, ok := core.env.data[string(key)]; ok && !core.private[key] {
+			return core.env, key, nil
+		}
+	}
+	nsName, name, qualified := splitQualified(key)
+	if !qualified {
+		return nil, "", fmt.Errorf("'%v' not found", key)
+	}
+	target := ns.aliases[nsName]
+	if target == nil {
+		target = ns.registry.namespaces[nsName]
+	}
+	if target == nil {
+		return nil, "", fmt.Errorf("no namespace '%v' found for '%v'", nsName, key)
+	} else if _, ok := target.env.data[string(name)]; !ok {
+		return nil, "", fmt.Errorf("'%v' not found", key)
+	} else if target != ns && target.private[name] {
+		return nil, "", fmt.Errorf("'%v' is private", key)
+	}
+	return target.env, name, nil
+}
+
+// visible returns the symbols that can be used from the namespace besides its
+// own definitions
+func (ns *Namespace) visible() []types.Symbol {
+	symbols := []types.Symbol{}
+	for name := range ns.refers {
+		symbols = append(symbols, name)
+	}
+	for alias, target := range ns.aliases {
+		for _, name := range target.Publics() {
+			symbols = append(symbols, alias+"/"+name)
+		}
+	}
+	if core := ns.registry.core; core != ns {
+		symbols = append(symbols, core.Publics()...)
+	}
+	return symbols
+}
+
+// splitQualified splits a symbol like ns/name into its parts. The division
+// function / is not qualified.
+func splitQualified(key types.Symbol) (types.Symbol, types.Symbol, bool) {
+	idx := strings.Index(string(key), "/")
+	if idx <= 0 || idx == len(key)-1 {
+		return "", "", false
+	}
+	return key[:idx], key[idx+1:], true
+}
@@ -0,0 +1,666 @@
+// Package image saves an environment to a file and restores it so that the
+// definitions do not have to be read and evaluated again at startup.
+//
+// An image starts with a table of the envs, atoms and functions in the
+// environment so that values that share them, or refer to themselves, still do
+// once they are loaded. Functions written in Go are saved by the name that they
+// have in the core namespace and are looked up by that name when loading.
+package image
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/tanema/mal/src/env"
+	"github.com/tanema/mal/src/printer"
+	"github.com/tanema/mal/src/runtime"
+	"github.com/tanema/mal/src/types"
+)
+
+const magic = "WOTIMG01"
+
+// maxDepth is how deeply values can be nested in an image, so that a corrupt
+// image cannot overflow the stack
+const maxDepth = 100000
+
+// value tags
+const (
+	tagNil byte = iota
+	tagTrue
+	tagFalse
+	tagNumber
+	tagString
+	tagSymbol
+	tagKeyword
+	tagList
+	tagVector
+	tagHashmap
+	tagBuiltin
+	tagNamespace
+	tagRef
+	tagRegex
+	tagChar
+	tagTagged
+	tagSortedMap
+)
+
+// object kinds
+const (
+	kindEnv byte = iota
+	kindAtom
+	kindFunc
+)
+
+// ErrInvalidImage is returned when a file is not an image or is corrupt
+var ErrInvalidImage = errors.New("invalid image")
+
+// Save writes every namespace in the environment to the image. Go functions
+// can only be saved if they are defined in the core namespace or in a namespace
+// of Go functions, like string, and are saved by the name they are defined with.
+func Save(w io.Writer, e *env.Env) error {
+	registry := e.Namespace().Registry()
+	enc := &encoder{
+		w:        bufio.NewWriter(w),
+		ids:      map[interface{}]int{},
+		builtins: map[types.Base]types.Symbol{},
+	}
+	namespaces := registry.All()
+	for _, def := range builtins(registry) {
+		if _, found := enc.builtins[def.val]; !found {
+			enc.builtins[def.val] = def.name
+		}
+	}
+	for _, ns := range namespaces {
+		if err := enc.visit(ns.Env()); err != nil {
+			return err
+		}
+	}
+
+	enc.writeString(magic)
+	enc.writeUint(len(enc.objects))
+	for _, obj := range enc.objects {
+		enc.writeHeader(obj)
+	}
+	for _, obj := range enc.objects {
+		enc.writeObject(obj)
+	}
+	enc.writeUint(len(namespaces))
+	for _, ns := range namespaces {
+		enc.writeNamespace(ns)
+	}
+	enc.writeString(string(registry.Current().Name))
+	if enc.err != nil {
+		return enc.err
+	}
+	return enc.w.Flush()
+}
+
+// Load restores the namespaces in the image into e, which should be an
+// environment made with core.BuiltinNamespace. Any other Go functions that the
+// image uses have to be defined with the same names before it is loaded.
+func Load(r io.Reader, e *env.Env) error {
+	registry := e.Namespace().Registry()
+	dec := &decoder{r: bufio.NewReader(r), registry: registry, builtins: map[types.Symbol]types.Base{}}
+	for _, def := range builtins(registry) {
+		dec.builtins[def.name] = def.val
+	}
+	if dec.readString() != magic {
+		return ErrInvalidImage
+	}
+	count := dec.readUint()
+	for i := 0; i < count && dec.err == nil; i++ {
+		dec.readHeader()
+	}
+	for i := 0; i < count && dec.err == nil; i++ {
+		dec.readObject(dec.objects[i])
+	}
+	nsCount := dec.readUint()
+	for i := 0; i < nsCount && dec.err == nil; i++ {
+		dec.readNamespace()
+	}
+	current := dec.registry.Find(types.Symbol(dec.readString()))
+	if dec.err != nil {
+		return dec.err
+	} else if current == nil {
+		return ErrInvalidImage
+	}
+	dec.registry.SetCurrent(current)
+	return nil
+}
+
+type encoder struct {
+	w        *bufio.Writer
+	ids      map[interface{}]int
+	objects  []interface{}
+	builtins map[types.Base]types.Symbol
+	err      error
+}
+
+func (enc *encoder) add(obj interface{}) {
+	enc.ids[obj] = len(enc.objects)
+	enc.objects = append(enc.objects, obj)
+}
+
+// visit gives an id to every object that can be reached from the value. The
+// outer env of an env is always given an id first so that it can be created
+// first when loading.
+func (enc *encoder) visit(val types.Base) error {
+	switch tval := val.(type) {
+	case *env.Env:
+		if _, seen := enc.ids[tval]; seen {
+			return nil
+		}
+		if outer := tval.Outer(); outer != nil {
+			if err := enc.visit(outer); err != nil {
+				return err
+			}
+		}
+		enc.add(tval)
+		defs := tval.Definitions()
+		for _, name := range sortedNames(defs) {
+			if err := enc.visit(defs[name]); err != nil {
+				return err
+			}
+		}
+	case *types.Atom:
+		if _, seen := enc.ids[tval]; seen {
+			return nil
+		}
+		enc.add(tval)
+		return enc.visit(tval.Val)
+	case *types.ExtFunc:
+		if _, seen := enc.ids[tval]; seen {
+			return nil
+		}
+		fnEnv, ok := tval.Env.(*env.Env)
+		if !ok {
+			return fmt.Errorf("cannot save function with env of type %T", tval.Env)
+		}
+		enc.add(tval)
+		for _, child := range append([]types.Base{tval.AST, tval.Meta, fnEnv}, tval.Params...) {
+			if err := enc.visit(child); err != nil {
+				return err
+			}
+		}
+	case *types.StdFunc:
+		if _, ok := enc.builtins[tval]; !ok || tval.Meta != nil {
+			return fmt.Errorf("cannot save function %v, it is not defined in the core namespace or a namespace of Go functions", printer.Print(tval, true))
+		}
+	case *types.Handle:
+		if _, ok := enc.builtins[tval]; !ok {
+			return fmt.Errorf("cannot save the open file %v", printer.Print(tval, true))
+		}
+	case *types.List:
+		return enc.visitAll(tval.Meta, tval.Forms)
+	case *types.Vector:
+		return enc.visitAll(tval.Meta, tval.Forms)
+	case *types.Hashmap:
+		if err := enc.visit(tval.Comparator); err != nil {
+			return err
+		}
+		return enc.visitAll(tval.Meta, tval.ToList())
+	case *types.Tagged:
+		return enc.visit(tval.Form)
+	case nil, bool, float64, string, types.Symbol, types.Keyword, *env.Namespace, *types.Regex, types.Char:
+	default:
+		return fmt.Errorf("cannot save value of type %T", val)
+	}
+	return nil
+}
+
+func (enc *encoder) visitAll(meta types.Base, forms []types.Base) error {
+	if err := enc.visit(meta); err != nil {
+		return err
+	}
+	for _, form := range forms {
+		if err := enc.visit(form); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (enc *encoder) writeHeader(obj interface{}) {
+	switch tobj := obj.(type) {
+	case *env.Env:
+		enc.writeByte(kindEnv)
+		nsName := ""
+		if ns := tobj.Namespace(); ns != nil && ns.Env() == tobj {
+			nsName = string(ns.Name)
+		}
+		enc.writeString(nsName)
+		if outer := tobj.Outer(); outer != nil {
+			enc.writeUint(enc.ids[outer] + 1)
+		} else {
+			enc.writeUint(0)
+		}
+	case *types.Atom:
+		enc.writeByte(kindAtom)
+	case *types.ExtFunc:
+		enc.writeByte(kindFunc)
+	}
+}
+
+func (enc *encoder) writeObject(obj interface{}) {
+	switch tobj := obj.(type) {
+	case *env.Env:
+		defs := tobj.Definitions()
+		names := sortedNames(defs)
+		enc.writeUint(len(names))
+		for _, name := range names {
+			enc.writeString(string(name))
+			enc.writeValue(defs[name])
+		}
+	case *types.Atom:
+		enc.writeValue(tobj.Val)
+	case *types.ExtFunc:
+		enc.writeValue(tobj.AST)
+		enc.writeForms(tobj.Params)
+		enc.writeUint(enc.ids[tobj.Env.(*env.Env)])
+		enc.writeBool(tobj.IsMacro)
+		enc.writeValue(tobj.Meta)
+	}
+}
+
+func (enc *encoder) writeNamespace(ns *env.Namespace) {
+	enc.writeString(string(ns.Name))
+	enc.writeString(ns.Doc)
+	enc.writeNsMap(ns.Aliases())
+	enc.writeNsMap(ns.Refers())
+	privates := ns.Privates()
+	enc.writeUint(len(privates))
+	for _, name := range privates {
+		enc.writeString(string(name))
+	}
+}
+
+func (enc *encoder) writeNsMap(m map[types.Symbol]*env.Namespace) {
+	names := []types.Symbol{}
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	enc.writeUint(len(names))
+	for _, name := range names {
+		enc.writeString(string(name))
+		enc.writeString(string(m[name].Name))
+	}
+}
+
+func (enc *encoder) writeValue(val types.Base) {
+	switch tval := val.(type) {
+	case nil:
+		enc.writeByte(tagNil)
+	case bool:
+		if tval {
+			enc.writeByte(tagTrue)
+		} else {
+			enc.writeByte(tagFalse)
+		}
+	case float64:
+		enc.writeByte(tagNumber)
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(tval))
+		enc.write(buf[:])
+	case string:
+		enc.writeByte(tagString)
+		enc.writeString(tval)
+	case types.Symbol:
+		enc.writeByte(tagSymbol)
+		enc.writeString(string(tval))
+	case types.Keyword:
+		enc.writeByte(tagKeyword)
+		enc.writeString(string(tval))
+	case *types.List:
+		enc.writeByte(tagList)
+		enc.writeValue(tval.Meta)
+		enc.writeForms(tval.Forms)
+	case *types.Vector:
+		enc.writeByte(tagVector)
+		enc.writeValue(tval.Meta)
+		enc.writeForms(tval.Forms)
+	case *types.Hashmap:
+		if tval.Comparator != nil {
+			enc.writeByte(tagSortedMap)
+			enc.writeValue(tval.Comparator)
+		} else {
+			enc.writeByte(tagHashmap)
+		}
+		enc.writeValue(tval.Meta)
+		enc.writeForms(tval.ToList())
+	case *types.StdFunc, *types.Handle:
+		enc.writeByte(tagBuiltin)
+		enc.writeString(string(enc.builtins[tval]))
+	case *env.Namespace:
+		enc.writeByte(tagNamespace)
+		enc.writeString(string(tval.Name))
+	case *types.Regex:
+		enc.writeByte(tagRegex)
+		enc.writeString(tval.String())
+	case types.Char:
+		enc.writeByte(tagChar)
+		enc.writeUint(int(tval))
+	case *types.Tagged:
+		enc.writeByte(tagTagged)
+		enc.writeString(string(tval.Tag))
+		enc.writeValue(tval.Form)
+	default:
+		enc.writeByte(tagRef)
+		enc.writeUint(enc.ids[val])
+	}
+}
+
+func (enc *encoder) writeForms(forms []types.Base) {
+	enc.writeUint(len(forms))
+	for _, form := range forms {
+		enc.writeValue(form)
+	}
+}
+
+func (enc *encoder) writeString(str string) {
+	enc.writeUint(len(str))
+	enc.write([]byte(str))
+}
+
+func (enc *encoder) writeUint(n int) {
+	var buf [binary.MaxVarintLen64]byte
+	enc.write(buf[:binary.PutUvarint(buf[:], uint64(n))])
+}
+
+func (enc *encoder) writeBool(b bool) {
+	if b {
+		enc.writeByte(1)
+	} else {
+		enc.writeByte(0)
+	}
+}
+
+func (enc *encoder) writeByte(b byte) {
+	enc.write([]byte{b})
+}
+
+func (enc *encoder) write(data []byte) {
+	if enc.err == nil {
+		_, enc.err = enc.w.Write(data)
+	}
+}
+
+type decoder struct {
+	r        *bufio.Reader
+	registry *env.Registry
+	builtins map[types.Symbol]types.Base
+	objects  []interface{}
+	depth    int
+	err      error
+}
+
+// readHeader creates an object so that it can be referred to before its
+// contents are read
+func (dec *decoder) readHeader() {
+	switch dec.readByte() {
+	case kindEnv:
+		nsName := dec.readString()
+		outer := dec.readUint()
+		if nsName != "" {
+			dec.objects = append(dec.objects, dec.registry.Create(types.Symbol(nsName)).Env())
+		} else if outer == 0 || outer > len(dec.objects) {
+			dec.fail()
+		} else if outerEnv, ok := dec.objects[outer-1].(*env.Env); !ok {
+			dec.fail()
+		} else {
+			newEnv, _ := env.New(outerEnv, nil, nil)
+			dec.objects = append(dec.objects, newEnv)
+		}
+	case kindAtom:
+		dec.objects = append(dec.objects, &types.Atom{})
+	case kindFunc:
+		fn, _ := types.NewFunc(nil, runtime.Eval, types.NewVect(), nil)
+		dec.objects = append(dec.objects, fn)
+	default:
+		dec.fail()
+	}
+}
+
+func (dec *decoder) readObject(obj interface{}) {
+	switch tobj := obj.(type) {
+	case *env.Env:
+		count := dec.readUint()
+		for i := 0; i < count && dec.err == nil; i++ {
+			name := types.Symbol(dec.readString())
+			tobj.Set(name, dec.readValue())
+		}
+	case *types.Atom:
+		tobj.Val = dec.readValue()
+	case *types.ExtFunc:
+		tobj.AST = dec.readValue()
+		tobj.Params = dec.readForms()
+		fnEnv, ok := dec.object(dec.readUint()).(*env.Env)
+		if !ok {
+			dec.fail()
+			return
+		}
+		tobj.Env = fnEnv
+		tobj.IsMacro = dec.readBool()
+		tobj.Meta = dec.readValue()
+	}
+}
+
+func (dec *decoder) readNamespace() {
+	ns := dec.registry.Create(types.Symbol(dec.readString()))
+	ns.Doc = dec.readString()
+	aliases := dec.readUint()
+	for i := 0; i < aliases && dec.err == nil; i++ {
+		alias := types.Symbol(dec.readString())
+		ns.Alias(alias, dec.registry.Create(types.Symbol(dec.readString())))
+	}
+	refers := dec.readUint()
+	for i := 0; i < refers && dec.err == nil; i++ {
+		name := types.Symbol(dec.readString())
+		if err := ns.Refer(dec.registry.Create(types.Symbol(dec.readString())), name); err != nil && dec.err == nil {
+			dec.err = err
+		}
+	}
+	privates := dec.readUint()
+	for i := 0; i < privates && dec.err == nil; i++ {
+		ns.Env().SetPrivate(types.Symbol(dec.readString()))
+	}
+}
+
+func (dec *decoder) readValue() types.Base {
+	if dec.depth++; dec.depth > maxDepth {
+		dec.fail()
+		return nil
+	}
+	defer func() { dec.depth-- }()
+	switch dec.readByte() {
+	case tagNil:
+		return nil
+	case tagTrue:
+		return true
+	case tagFalse:
+		return false
+	case tagNumber:
+		var buf [8]byte
+		dec.read(buf[:])
+		return math.Float64frombits(binary.LittleEndian.Uint64(buf[:]))
+	case tagString:
+		return dec.readString()
+	case tagSymbol:
+		return types.Symbol(dec.readString())
+	case tagKeyword:
+		return types.Keyword(dec.readString())
+	case tagList:
+		meta := dec.readValue()
+		return &types.List{Forms: dec.readForms(), Meta: meta}
+	case tagVector:
+		meta := dec.readValue()
+		return &types.Vector{Forms: dec.readForms(), Meta: meta}
+	case tagHashmap:
+		meta := dec.readValue()
+		hm, err := types.NewHashmap(dec.readForms())
+		if err != nil {
+			dec.fail()
+			return nil
+		}
+		hm.Meta = meta
+		return hm
+	case tagSortedMap:
+		comparator := dec.readValue()
+		meta := dec.readValue()
+		forms := dec.readForms()
+		hm, err := types.NewHashmap(forms)
+		if err != nil {
+			dec.fail()
+			return nil
+		}
+		hm.Meta, hm.Comparator = meta, comparator
+		for i := 0; i < len(forms); i += 2 {
+			hm.SortedKeys = append(hm.SortedKeys, forms[i])
+		}
+		return hm
+	case tagBuiltin:
+		name := types.Symbol(dec.readString())
+		val, ok := dec.builtins[name]
+		if !ok && dec.err == nil {
+			dec.err = fmt.Errorf("image uses the builtin %v which is not defined", name)
+		}
+		return val
+	case tagNamespace:
+		return dec.registry.Create(types.Symbol(dec.readString()))
+	case tagRef:
+		return dec.object(dec.readUint())
+	case tagChar:
+		return types.Char(dec.readUint())
+	case tagTagged:
+		tag := types.Symbol(dec.readString())
+		return &types.Tagged{Tag: tag, Form: dec.readValue()}
+	case tagRegex:
+		re, err := types.NewRegex(dec.readString())
+		if err != nil {
+			dec.fail()
+			return nil
+		}
+		return re
+	default:
+		dec.fail()
+		return nil
+	}
+}
+
+func (dec *decoder) object(id int) interface{} {
+	if id >= len(dec.objects) {
+		dec.fail()
+		return nil
+	}
+	return dec.objects[id]
+}
+
+func (dec *decoder) readForms() []types.Base {
+	count := dec.readUint()
+	forms := []types.Base{}
+	for i := 0; i < count && dec.err == nil; i++ {
+		forms = append(forms, dec.readValue())
+	}
+	return forms
+}
+
+func (dec *decoder) readString() string {
+	n := dec.readUint()
+	if dec.err != nil {
+		return ""
+	}
+	// the data is copied as it is read so that a corrupt length cannot make
+	// room for more data than the image has
+	var data strings.Builder
+	if _, err := io.CopyN(&data, dec.r, int64(n)); err != nil {
+		dec.fail()
+		return ""
+	}
+	return data.String()
+}
+
+func (dec *decoder) readUint() int {
+	if dec.err != nil {
+		return 0
+	}
+	n, err := binary.ReadUvarint(dec.r)
+	if err != nil || n > math.MaxInt32 {
+		dec.fail()
+		return 0
+	}
+	return int(n)
+}
+
+func (dec *decoder) readBool() bool {
+	return dec.readByte() == 1
+}
+
+func (dec *decoder) readByte() byte {
+	var buf [1]byte
+	dec.read(buf[:])
+	return buf[0]
+}
+
+func (dec *decoder) read(data []byte) {
+	if dec.err == nil {
+		if _, err := io.ReadFull(dec.r, data); err != nil {
+			dec.fail()
+		}
+	}
+}
+
+func (dec *decoder) fail() {
+	if dec.err == nil {
+		dec.err = ErrInvalidImage
+	}
+}
+
+type builtin struct {
+	name types.Symbol
+	val  types.Base
+}
+
+// builtins lists the Go functions in the registry, and the open files of the
+// core namespace like *in*, by the name that they are saved with. Values in
+// the core namespace come first with their own names, followed by the
+// functions in other namespaces with qualified names like string/join.
+func builtins(registry *env.Registry) []builtin {
+	defs := []builtin{}
+	core := registry.Core()
+	namespaces := append([]*env.Namespace{core}, registry.All()...)
+	for i, ns := range namespaces {
+		if i > 0 && ns == core {
+			continue
+		}
+		nsDefs := ns.Env().Definitions()
+		for _, name := range sortedNames(nsDefs) {
+			switch val := nsDefs[name].(type) {
+			case *types.Handle:
+				if ns == core {
+					defs = append(defs, builtin{name: name, val: val})
+				}
+			case *types.StdFunc:
+				if ns != core {
+					name = ns.Name + "/" + name
+				}
+				defs = append(defs, builtin{name: name, val: val})
+			}
+		}
+	}
+	return defs
+}
+
+func sortedNames(defs map[types.Symbol]types.Base) []types.Symbol {
+	names := make([]types.Symbol, 0, len(defs))
+	for name := range defs {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
@@ -0,0 +1,151 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	goruntime "runtime"
+	"testing"
+
+	"github.com/tanema/mal/src/core"
+	"github.com/tanema/mal/src/env"
+	"github.com/tanema/mal/src/printer"
+	"github.com/tanema/mal/src/reader"
+	"github.com/tanema/mal/src/runtime"
+	"github.com/tanema/mal/src/types"
+)
+
+// imageProgram defines a value with every tag, along with values that share
+// atoms and envs and an atom that holds itself
+const imageProgram = `
+(def! values [nil true false -1.5 "str" 'sym :kw '(1 (2)) ^{:m 1} [3] {:a {"b" [4]}}
+              + string/join *in* *ns* #"a+\d" \a \newline
+              (edn/tagged-literal 'point [1 2]) (sorted-map 3 :c 1 :a 2 :b)])
+(def! by-length (sorted-map-by (fn* [a b] (< (count a) (count b))) "ccc" 3 "a" 1 "bb" 2))
+(def! shared (atom 1))
+(def! pair [shared shared])
+(def! self (atom nil))
+(reset! self [self])
+(def! counter ((fn* [n] (fn* [] (swap! n (fn* [x] (+ x 1))))) (atom 0)))
+(counter)
+(defmacro! unless (fn* [test then] (list 'if test nil then)))
+(in-ns 'other)
+(def! secret 42)
+(wot.core/in-ns 'user)
+(def! other-secret other/secret)
+`
+
+func newEnv(t testing.TB, src string) *env.Env {
+	e := core.BuiltinNamespace()
+	forms, err := reader.ReadAll(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, form := range forms {
+		if _, err := runtime.Eval(e.Current(), form); err != nil {
+			t.Fatalf("%v: %v", printer.Print(form, true), err)
+		}
+	}
+	return e
+}
+
+func eval(t *testing.T, e *env.Env, src string) string {
+	form, err := reader.ReadString(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	val, err := runtime.Eval(e.Current(), form)
+	if err != nil {
+		t.Fatalf("%v: %v", src, err)
+	}
+	return printer.Print(val, true)
+}
+
+func save(t testing.TB, e *env.Env) []byte {
+	var buf bytes.Buffer
+	if err := Save(&buf, e); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	saved := newEnv(t, imageProgram)
+	loaded := core.BuiltinNamespace()
+	if err := Load(bytes.NewReader(save(t, saved)), loaded); err != nil {
+		t.Fatal(err)
+	}
+	for _, src := range []string{"values", "by-length", "pair", "(counter)", "(unless false 1)", "other-secret", "(ns-name *ns*)"} {
+		if expected, got := eval(t, saved, src), eval(t, loaded, src); got != expected {
+			t.Errorf("%v = %v after loading, expected %v", src, got, expected)
+		}
+	}
+	if got := eval(t, loaded, "(do (reset! (first pair) 5) @(nth pair 1))"); got != "5" {
+		t.Errorf("the atoms in pair are not shared after loading, got %v", got)
+	}
+	self, _ := loaded.Current().Get("self")
+	if atom, ok := self.(*types.Atom); !ok || atom.Val.(*types.Vector).Forms[0] != atom {
+		t.Errorf("self does not hold itself after loading")
+	}
+	values, _ := loaded.Current().Get("values")
+	in, _ := loaded.Get("*in*")
+	plus, _ := loaded.Get("+")
+	if forms := values.(*types.Vector).Forms; forms[10] != plus || forms[12] != in {
+		t.Errorf("builtins are not the ones in the environment after loading")
+	}
+	if got := eval(t, loaded, "(subseq by-length > \"a\")"); got != `(["bb" 2] ["ccc" 3])` {
+		t.Errorf("the sorted map by length is out of order after loading, got %v", got)
+	}
+}
+
+func TestLoadTruncated(t *testing.T) {
+	data := save(t, newEnv(t, imageProgram))
+	for n := 0; n < len(data); n++ {
+		if err := Load(bytes.NewReader(data[:n]), core.BuiltinNamespace()); err != ErrInvalidImage {
+			t.Fatalf("loading the first %v of %v bytes returned %v", n, len(data), err)
+		}
+	}
+}
+
+func TestLoadBadMagic(t *testing.T) {
+	data := save(t, newEnv(t, ""))
+	copy(data[1:], "NOTANIMG")
+	if err := Load(bytes.NewReader(data), core.BuiltinNamespace()); err != ErrInvalidImage {
+		t.Errorf("loading an image with a bad magic number returned %v", err)
+	}
+}
+
+// TestLoadOversizedLength checks that a string length near the limit does not
+// allocate memory for data that is not in the image
+func TestLoadOversizedLength(t *testing.T) {
+	var buf [binary.MaxVarintLen64]byte
+	data := append(buf[:binary.PutUvarint(buf[:], math.MaxInt32)], "WOTIMG01"...)
+	e := core.BuiltinNamespace()
+	var before, after goruntime.MemStats
+	goruntime.ReadMemStats(&before)
+	if err := Load(bytes.NewReader(data), e); err != ErrInvalidImage {
+		t.Errorf("loading an image with an oversized length returned %v", err)
+	}
+	goruntime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 16<<20 {
+		t.Errorf("loading an image with an oversized length allocated %v bytes", allocated)
+	}
+}
+
+func TestLoadTooDeep(t *testing.T) {
+	data := save(t, newEnv(t, "(def! deep nil)"))
+	nested := bytes.Repeat([]byte{tagList, tagNil, 1}, maxDepth+1)
+	data = bytes.Replace(data, []byte("deep\x00"), append([]byte("deep"), nested...), 1)
+	if err := Load(bytes.NewReader(data), core.BuiltinNamespace()); err != ErrInvalidImage {
+		t.Errorf("loading an image with values nested too deeply returned %v", err)
+	}
+}
+
+// FuzzLoad checks that corrupt images are errors and not panics
+func FuzzLoad(f *testing.F) {
+	f.Add(save(f, newEnv(f, "")))
+	f.Add(save(f, newEnv(f, `(def! a (atom [1 {:b #"c"} \d])) (reset! a a) (def! f (fn* [x] (+ x 1)))`)))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		Load(bytes.NewReader(data), core.BuiltinNamespace())
+	})
+}
@@ -0,0 +1,294 @@
+package printer
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tanema/mal/src/types"
+)
+
+// bodyForms are the forms that are laid out as code with their bodies indented
+// by two spaces, and how many of their arguments stay on the first line
+var bodyForms = map[types.Symbol]int{
+	"def!":      1,
+	"defmacro!": 1,
+	"defn":      1,
+	"defn-":     1,
+	"fn*":       1,
+	"let*":      1,
+	"if":        1,
+	"do":        0,
+	"try*":      0,
+	"catch*":    2,
+	"ns":        1,
+	"cond":      0,
+	"with-open": 1,
+	"deftest":   1,
+}
+
+// bindingForms are the forms with a vector of bindings that is laid out a pair
+// to a line
+var bindingForms = map[types.Symbol]bool{
+	"let*":      true,
+	"with-open": true,
+}
+
+// Pretty prints the value readably, breaking the collections that do not fit
+// in width columns over several lines. Data is laid out with the items of each
+// collection lined up after its opening bracket. When code is true lists are
+// laid out like source, with the bodies of forms like defn and let* indented by
+// two spaces and the arguments of other calls lined up after the first one.
+func Pretty(val types.Base, width int, code bool) string {
+	return Options{}.Pretty(val, width, code)
+}
+
+// Pretty prints the value like the Pretty function, within the limits of the
+// options
+func (opts Options) Pretty(val types.Base, width int, code bool) string {
+	p := &pretty{state: &state{Options: opts, pretty: true}, code: code}
+	return layout(p.doc(val), width)
+}
+
+// doc is a document for the layout: text, a line that is a space unless its
+// group is broken, a concatenation, or a group that is either laid out on one
+// line or has all of its lines broken
+type doc interface{}
+
+type (
+	text   string
+	line   struct{}
+	concat []doc
+	group  struct{ doc doc }
+	// nest indents the lines in the doc further than the lines around it
+	nest struct {
+		indent int
+		doc    doc
+	}
+	// align indents the lines in the doc to the column that it starts at
+	align struct{ doc doc }
+)
+
+// pretty builds the docs of values, printing everything other than lists,
+// vectors and maps with its state
+type pretty struct {
+	*state
+	code bool
+}
+
+func (p *pretty) doc(val types.Base) doc {
+	var meta types.Base
+	switch tval := val.(type) {
+	case *types.List:
+		meta = tval.Meta
+	case *types.Vector:
+		meta = tval.Meta
+	case *types.Hashmap:
+		meta = tval.Meta
+	default:
+		return text(p.print(val))
+	}
+	return p.nested(meta, func() doc { return p.coll(val) })
+}
+
+// nested builds the doc of a collection one level deeper, or # if it is nested
+// past the Level of the options
+func (p *pretty) nested(meta types.Base, build func() doc) doc {
+	if past(p.Level, p.depth) {
+		return text("#")
+	}
+	p.depth++
+	defer func() { p.depth-- }()
+	d := build()
+	if p.Meta && meta != nil {
+		return concat{text("^"), p.doc(meta), text(" "), d}
+	}
+	return d
+}
+
+func (p *pretty) coll(val types.Base) doc {
+	switch tval := val.(type) {
+	case *types.List:
+		if head, isSym := first(tval.Forms).(types.Symbol); p.code && isSym && p.whole(tval.Forms) {
+			return p.form(head, tval.Forms[1:])
+		}
+		return p.items("(", tval.Forms, ")")
+	case *types.Vector:
+		return p.items("[", tval.Forms, "]")
+	default:
+		return p.pairs("{", val.(*types.Hashmap).ToList(), "}")
+	}
+}
+
+func first(forms []types.Base) types.Base {
+	if len(forms) == 0 {
+		return nil
+	}
+	return forms[0]
+}
+
+// docs builds the docs of the forms, leaving out the ones past the Length of
+// the options
+func (p *pretty) docs(forms []types.Base) []doc {
+	docs := make([]doc, 0, len(forms))
+	for i, form := range forms {
+		if past(p.Length, i) {
+			return append(docs, text("..."))
+		}
+		docs = append(docs, p.doc(form))
+	}
+	return docs
+}
+
+// whole checks that none of the forms are left out, so that they can be laid
+// out as code
+func (p *pretty) whole(forms []types.Base) bool {
+	return p.Length == nil || len(forms) <= *p.Length
+}
+
+// items lines the items up after the opening bracket
+func (p *pretty) items(open string, forms []types.Base, close string) doc {
+	return group{concat{text(open), align{join(p.docs(forms))}, text(close)}}
+}
+
+// pairs lays out the forms a pair to a line, like the keys and values of a map
+func (p *pretty) pairs(open string, forms []types.Base, close string) doc {
+	return group{concat{text(open), align{join(p.pairDocs(forms))}, text(close)}}
+}
+
+func (p *pretty) pairDocs(forms []types.Base) []doc {
+	docs := []doc{}
+	for i := 0; i < len(forms); i += 2 {
+		if past(p.Length, i/2) {
+			return append(docs, text("..."))
+		} else if i+1 == len(forms) {
+			docs = append(docs, p.doc(forms[i]))
+		} else {
+			docs = append(docs, concat{p.doc(forms[i]), text(" "), p.doc(forms[i+1])})
+		}
+	}
+	return docs
+}
+
+// form lays out a list that starts with a symbol as code
+func (p *pretty) form(head types.Symbol, args []types.Base) doc {
+	headers, isBody := bodyForms[head]
+	if !isBody {
+		if len(args) == 0 {
+			return text("(" + string(head) + ")")
+		}
+		return group{concat{text("(" + string(head) + " "), align{join(p.docs(args))}, text(")")}}
+	}
+	if headers > len(args) {
+		headers = len(args)
+	}
+	start := concat{text("(" + string(head))}
+	for i, arg := range args[:headers] {
+		if vect, isVect := arg.(*types.Vector); isVect && i == 0 && bindingForms[head] && p.whole(vect.Forms) {
+			start = append(start, text(" "), p.nested(vect.Meta, func() doc { return p.pairs("[", vect.Forms, "]") }))
+		} else {
+			start = append(start, text(" "), p.doc(arg))
+		}
+	}
+	body := p.docs(args[headers:])
+	if head == "cond" {
+		body = p.pairDocs(args)
+	}
+	if len(body) > 0 {
+		start = append(start, nest{2, concat{line{}, join(body)}})
+	}
+	return align{group{append(start, text(")"))}}
+}
+
+func join(docs []doc) doc {
+	joined := concat{}
+	for i, d := range docs {
+		if i > 0 {
+			joined = append(joined, line{})
+		}
+		joined = append(joined, d)
+	}
+	return joined
+}
+
+// frame is a doc waiting to be laid out, with the indent of its lines and
+// whether it is in a group that is on one line
+type frame struct {
+	indent int
+	flat   bool
+	doc    doc
+}
+
+// layout writes out the doc, putting each group on one line if it fits in the
+// width up to the next line break after it
+func layout(d doc, width int) string {
+	var out strings.Builder
+	col := 0
+	stack := []frame{{doc: d}}
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		switch td := f.doc.(type) {
+		case text:
+			out.WriteString(string(td))
+			col += utf8.RuneCountInString(string(td))
+		case line:
+			if f.flat {
+				out.WriteByte(' ')
+				col++
+			} else {
+				out.WriteString("\n" + strings.Repeat(" ", f.indent))
+				col = f.indent
+			}
+		case concat:
+			for i := len(td) - 1; i >= 0; i-- {
+				stack = append(stack, frame{f.indent, f.flat, td[i]})
+			}
+		case nest:
+			stack = append(stack, frame{f.indent + td.indent, f.flat, td.doc})
+		case align:
+			stack = append(stack, frame{col, f.flat, td.doc})
+		case group:
+			flat := f.flat || fits(width-col, frame{f.indent, true, td.doc}, stack)
+			stack = append(stack, frame{f.indent, flat, td.doc})
+		}
+	}
+	return out.String()
+}
+
+// fits checks if the frame, followed by the rest of the stack, fits in the
+// width up to the next line break
+func fits(width int, f frame, rest []frame) bool {
+	pending := []frame{f}
+	next := len(rest) - 1
+	for width >= 0 {
+		if len(pending) == 0 {
+			if next < 0 {
+				return true
+			}
+			pending = append(pending, rest[next])
+			next--
+		}
+		f := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		switch td := f.doc.(type) {
+		case text:
+			width -= utf8.RuneCountInString(string(td))
+		case line:
+			if !f.flat {
+				return true
+			}
+			width--
+		case concat:
+			for i := len(td) - 1; i >= 0; i-- {
+				pending = append(pending, frame{f.indent, f.flat, td[i]})
+			}
+		case nest:
+			pending = append(pending, frame{f.indent, f.flat, td.doc})
+		case align:
+			pending = append(pending, frame{f.indent, f.flat, td.doc})
+		case group:
+			pending = append(pending, frame{f.indent, f.flat, td.doc})
+		}
+	}
+	return false
+}
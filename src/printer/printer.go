@@ -2,30 +2,123 @@ package printer
 
 import (
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/tanema/mal/src/env"
 	"github.com/tanema/mal/src/types"
 )
 
+// Options limit how much of a value is printed. The zero Options print all of
+// a value without its metadata.
+type Options struct {
+	// Length is the most items of each collection that are printed before the
+	// rest are left out as ..., or nil for no limit
+	Length *int
+	// Level is how deeply collections can be nested before they are printed as
+	// #, or nil for no limit
+	Level *int
+	// Meta prints the metadata of collections before them, like ^{:a 1} [x]
+	Meta bool
+}
+
+// Limit makes a Length or Level of n
+func Limit(n int) *int {
+	return &n
+}
+
+// past checks if n has reached the limit, which is never for a nil limit
+func past(limit *int, n int) bool {
+	return limit != nil && n >= *limit
+}
+
 // List nicely prints collection type values
 func List(forms []types.Base, pretty bool, pre, post, join string) string {
-	strList := make([]string, len(forms))
+	return Options{}.List(forms, pretty, pre, post, join)
+}
+
+// Print takes any value type and formats into a pleasant string. If the value
+// type is unrecognized then an error if logged. An atom that holds itself is
+// printed as (atom ...) where it appears inside of itself.
+func Print(object types.Base, pretty bool) string {
+	return Options{}.Print(object, pretty)
+}
+
+// List prints the values like the List function, leaving out the ones past the
+// Length of the options
+func (opts Options) List(forms []types.Base, pretty bool, pre, post, join string) string {
+	return (&state{Options: opts, pretty: pretty}).list(forms, 1, pre, post, join)
+}
+
+// Print prints the value like the Print function, within the limits of the
+// options
+func (opts Options) Print(object types.Base, pretty bool) string {
+	return (&state{Options: opts, pretty: pretty}).print(object)
+}
+
+// state keeps track of how deeply nested the value being printed is, and the
+// atoms that it is inside of so that an atom that holds itself is not printed
+// forever
+type state struct {
+	Options
+	pretty bool
+	depth  int
+	atoms  map[*types.Atom]bool
+}
+
+// list prints the forms, per forms to an item, like the keys and values of a
+// map
+func (s *state) list(forms []types.Base, per int, pre, post, join string) string {
+	strList := make([]string, 0, len(forms))
 	for i, e := range forms {
-		strList[i] = Print(e, pretty)
+		if past(s.Length, i/per) {
+			strList = append(strList, "...")
+			break
+		}
+		strList = append(strList, s.print(e))
 	}
 	return pre + strings.Join(strList, join) + post
 }
 
-// Print takes any value type and formats into a pleasant string. If the value
-// type is unrecognized then an error if logged
-func Print(object types.Base, pretty bool) string {
+// coll prints a collection, or # if it is nested past the Level of the
+// options
+func (s *state) coll(meta types.Base, forms []types.Base, per int, pre, post string) string {
+	if past(s.Level, s.depth) {
+		return "#"
+	}
+	s.depth++
+	defer func() { s.depth-- }()
+	prefix := ""
+	if s.Meta && s.pretty && meta != nil {
+		prefix = "^" + s.print(meta) + " "
+	}
+	return prefix + s.list(forms, per, pre, post, " ")
+}
+
+// atom prints the atom, unless it is already being printed because it holds
+// itself
+func (s *state) atom(atom *types.Atom) string {
+	if s.atoms[atom] {
+		return "(atom ...)"
+	} else if s.atoms == nil {
+		s.atoms = map[*types.Atom]bool{}
+	}
+	s.atoms[atom] = true
+	defer delete(s.atoms, atom)
+	return "(atom " + s.print(atom.Val) + ")"
+}
+
+func (s *state) print(object types.Base) string {
 	switch tobj := object.(type) {
 	case *types.Vector:
-		return List(tobj.Forms, pretty, "[", "]", " ")
+		return s.coll(tobj.Meta, tobj.Forms, 1, "[", "]")
 	case *types.List:
-		return List(tobj.Forms, pretty, "(", ")", " ")
+		return s.coll(tobj.Meta, tobj.Forms, 1, "(", ")")
 	case *types.Hashmap:
-		return List(tobj.ToList(), pretty, "{", "}", " ")
+		return s.coll(tobj.Meta, tobj.ToList(), 2, "{", "}")
 	case types.Symbol:
 		return string(tobj)
 	case types.Keyword:
@@ -37,21 +130,42 @@ func Print(object types.Base, pretty bool) string {
 		if tobj.IsMacro {
 			pre = "#<macro "
 		}
-		return pre + List(tobj.Params, pretty, "[", "]", ", ") + Print(tobj.AST, pretty) + ">"
+		return pre + s.list(tobj.Params, 1, "[", "]", ", ") + s.print(tobj.AST) + ">"
+	case *env.Namespace:
+		if s.pretty {
+			return "#namespace[" + tobj.String() + "]"
+		}
+		return tobj.String()
 	case *types.Atom:
-		return "(atom " + Print(tobj.Val, pretty) + ")"
+		return s.atom(tobj)
 	case types.UserError:
-		return "Exception: " + Print(tobj.Val, pretty)
+		return "Exception: " + s.print(tobj.Val)
 	case error:
 		return "Exception: " + tobj.Error()
 	case string:
-		if pretty {
-			tobj = strings.Replace(tobj, `\`, `\\`, -1)
-			tobj = strings.Replace(tobj, `"`, `\"`, -1)
-			tobj = strings.Replace(tobj, "\n", `\n`, -1)
-			return `"` + tobj + `"`
+		if s.pretty {
+			return quote(tobj)
 		}
 		return tobj
+	case types.Char:
+		if s.pretty {
+			return printChar(tobj)
+		}
+		return string(tobj)
+	case *types.Regex:
+		if s.pretty {
+			return `#"` + tobj.String() + `"`
+		}
+		return tobj.String()
+	case *types.Tagged:
+		return "#" + string(tobj.Tag) + " " + s.print(tobj.Form)
+	case *types.Matcher:
+		return "#<matcher " + Print(tobj.Regex, true) + ">"
+	case *types.Handle:
+		if tobj.Reader != nil {
+			return "#<reader " + tobj.Path + ">"
+		}
+		return "#<writer " + tobj.Path + ">"
 	case bool:
 		if tobj {
 			return "true"
@@ -60,8 +174,63 @@ func Print(object types.Base, pretty bool) string {
 	case nil:
 		return "nil"
 	case float64:
-		return fmt.Sprintf("%v", tobj)
+		return formatNumber(tobj)
 	default:
 		return "error formatting datatype"
 	}
 }
+
+// stringEsc are the escapes used for characters in readable strings
+var stringEsc = map[rune]string{
+	'\\': `\\`,
+	'"':  `\"`,
+	'\n': `\n`,
+	'\t': `\t`,
+	'\r': `\r`,
+	'\b': `\b`,
+	'\f': `\f`,
+}
+
+// quote prints a string readably, escaping quotes, backslashes and characters
+// that are not printable
+func quote(str string) string {
+	var out strings.Builder
+	out.WriteByte('"')
+	for len(str) > 0 {
+		ch, size := utf8.DecodeRuneInString(str)
+		if ch == utf8.RuneError && size == 1 {
+			out.WriteString(str[:1])
+		} else if esc, ok := stringEsc[ch]; ok {
+			out.WriteString(esc)
+		} else if !unicode.IsPrint(ch) && ch <= 0xFFFF {
+			fmt.Fprintf(&out, `\u%04x`, ch)
+		} else {
+			out.WriteRune(ch)
+		}
+		str = str[size:]
+	}
+	out.WriteByte('"')
+	return out.String()
+}
+
+// printChar prints a character literal with its name if it has one
+func printChar(ch types.Char) string {
+	for name, named := range types.CharNames {
+		if ch == named {
+			return `\` + name
+		}
+	}
+	if !unicode.IsPrint(rune(ch)) && ch <= 0xFFFF {
+		return fmt.Sprintf(`\u%04x`, ch)
+	}
+	return `\` + string(ch)
+}
+
+// formatNumber prints numbers without an exponent unless they are very large or
+// very small, so that whole numbers print as integers and can be read back
+func formatNumber(num float64) string {
+	if abs := math.Abs(num); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		return strconv.FormatFloat(num, 'e', -1, 64)
+	}
+	return strconv.FormatFloat(num, 'f', -1, 64)
+}
@@ -0,0 +1,247 @@
+package printer
+
+import (
+	"testing"
+
+	"github.com/tanema/mal/src/reader"
+	"github.com/tanema/mal/src/types"
+)
+
+// equalForms compares forms read by the reader. Hash maps are compared by
+// looking for an equal key because keys that are collections are pointers.
+func equalForms(a, b types.Base) bool {
+	switch x := a.(type) {
+	case *types.List:
+		y, ok := b.(*types.List)
+		return ok && equalSlices(x.Forms, y.Forms)
+	case *types.Vector:
+		y, ok := b.(*types.Vector)
+		return ok && equalSlices(x.Forms, y.Forms)
+	case *types.Hashmap:
+		y, ok := b.(*types.Hashmap)
+		if !ok || len(x.Forms) != len(y.Forms) {
+			return false
+		}
+		for key, val := range x.Forms {
+			found := false
+			for otherKey, otherVal := range y.Forms {
+				if equalForms(key, otherKey) && equalForms(val, otherVal) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	case *types.Regex:
+		y, ok := b.(*types.Regex)
+		return ok && x.String() == y.String()
+	case float64:
+		y, ok := b.(float64)
+		return ok && (x == y || x != x && y != y)
+	default:
+		return a == b
+	}
+}
+
+func equalSlices(a, b []types.Base) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !equalForms(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPrintNumbers(t *testing.T) {
+	cases := map[float64]string{
+		0:        "0",
+		-2:       "-2",
+		1000000:  "1000000",
+		0.25:     "0.25",
+		1e21:     "1e+21",
+		-1.5e-07: "-1.5e-07",
+	}
+	for num, expected := range cases {
+		if printed := Print(num, true); printed != expected {
+			t.Errorf("Print(%v) = %q, expected %q", num, printed, expected)
+		}
+	}
+}
+
+func TestPrintStrings(t *testing.T) {
+	cases := map[types.Base]string{
+		"tab\there":        `"tab\there"`,
+		"cr\r\nlf":         `"cr\r\nlf"`,
+		"caf\u00e9":        `"café"`,
+		"bell\a":           `"bell\u0007"`,
+		"bad\xffbyte":      "\"bad\xffbyte\"",
+		types.Char('a'):    `\a`,
+		types.Char('\n'):   `\newline`,
+		types.Char(' '):    `\space`,
+		types.Char('é'):    `\é`,
+		types.Char(0x00a0): `\u00a0`,
+	}
+	for val, expected := range cases {
+		if printed := Print(val, true); printed != expected {
+			t.Errorf("Print(%q) = %q, expected %q", val, printed, expected)
+		}
+	}
+}
+
+func TestPrintMaps(t *testing.T) {
+	expected := `{nil 5 9 4 10 3 "c" 2 s 6 :a 7 :b 1}`
+	for i := 0; i < 10; i++ {
+		hm, _ := types.NewHashmap([]types.Base{
+			types.Keyword("b"), 1.0, "c", 2.0, 10.0, 3.0, 9.0, 4.0, nil, 5.0, types.Symbol("s"), 6.0, types.Keyword("a"), 7.0,
+		})
+		if printed := Print(hm, true); printed != expected {
+			t.Fatalf("Print(hm) = %v, expected %v", printed, expected)
+		}
+	}
+	atoms := []types.Base{}
+	for i := 0; i < 10; i++ {
+		atoms = append(atoms, &types.Atom{Val: 1.0}, 1.0)
+	}
+	first, _ := types.NewHashmap(atoms)
+	for i := 0; i < 10; i++ {
+		hm, _ := types.NewHashmap(atoms)
+		keys, vals, firstKeys := hm.Keys(), hm.Vals(), first.Keys()
+		for j, key := range keys {
+			if key != firstKeys[j] {
+				t.Fatalf("keys that cannot be compared are in a different order in each map")
+			} else if vals[j] != hm.Forms[key] {
+				t.Fatalf("the values of the map are not in the order of the keys")
+			}
+		}
+	}
+}
+
+func TestPretty(t *testing.T) {
+	cases := []struct {
+		src      string
+		width    int
+		code     bool
+		expected string
+	}{
+		{"[1 2 3]", 80, false, "[1 2 3]"},
+		{`{:name "wot" :deps {:a [1 2 3] :b {:c "a long string"}} :tags [:x :y]}`, 30, false,
+			"{:deps {:a [1 2 3]\n        :b {:c \"a long string\"}}\n :name \"wot\"\n :tags [:x :y]}"},
+		{"(1 (2 3) 4)", 5, false, "(1\n (2\n  3)\n 4)"},
+		{"(defn fact [n] (if (= n 0) 1 (* n (fact (- n 1)))))", 30, true,
+			"(defn fact\n  [n]\n  (if (= n 0)\n    1\n    (* n (fact (- n 1)))))"},
+		{"(let* [a 1 b (+ a 2)] (println a b) (+ a b))", 20, true,
+			"(let* [a 1\n       b (+ a 2)]\n  (println a b)\n  (+ a b))"},
+		{`(cond (= x 1) "one" :else "many")`, 20, true, "(cond\n  (= x 1) \"one\"\n  :else \"many\")"},
+		{"(function-name argument-one argument-two)", 30, true, "(function-name argument-one\n               argument-two)"},
+		{"(function-name argument-one argument-two)", 30, false, "(function-name\n argument-one\n argument-two)"},
+	}
+	for _, c := range cases {
+		form, err := reader.ReadString(c.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if printed := Pretty(form, c.width, c.code); printed != c.expected {
+			t.Errorf("Pretty(%v, %v, %v) =\n%v\nexpected\n%v", c.src, c.width, c.code, printed, c.expected)
+		}
+	}
+}
+
+func TestPrintOptions(t *testing.T) {
+	meta, _ := types.NewHashmap([]types.Base{types.Keyword("m"), 1.0})
+	tagged, _ := types.WithMeta(types.NewVect(1.0, 2.0), meta)
+	cases := []struct {
+		src      string
+		opts     Options
+		expected string
+	}{
+		{"(1 2 3 4)", Options{Length: Limit(2)}, "(1 2 ...)"},
+		{"[1 [2 3 4] 5]", Options{Length: Limit(2)}, "[1 [2 3 ...] ...]"},
+		{"{:a 1 :b 2 :c 3}", Options{Length: Limit(2)}, "{:a 1 :b 2 ...}"},
+		{"[1 [2 [3 [4]]]]", Options{Level: Limit(2)}, "[1 [2 #]]"},
+		{"[[1 2 3] [4 5 6] [7]]", Options{Length: Limit(2), Level: Limit(2)}, "[[1 2 ...] [4 5 ...] ...]"},
+		{"(1 2)", Options{Length: Limit(2)}, "(1 2)"},
+		{"(1 2)", Options{Length: Limit(0)}, "(...)"},
+		{"{:a 1}", Options{Length: Limit(0)}, "{...}"},
+		{"[1 [2]]", Options{Level: Limit(0)}, "#"},
+		{"[1 [2]]", Options{Level: Limit(1)}, "[1 #]"},
+	}
+	for _, c := range cases {
+		form, err := reader.ReadString(c.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if printed := c.opts.Print(form, true); printed != c.expected {
+			t.Errorf("%+v.Print(%v) = %v, expected %v", c.opts, c.src, printed, c.expected)
+		}
+		if printed := c.opts.Pretty(form, 80, false); printed != c.expected {
+			t.Errorf("%+v.Pretty(%v) = %v, expected %v", c.opts, c.src, printed, c.expected)
+		}
+	}
+	if printed := (Options{Meta: true}).Print(types.NewList(tagged), true); printed != "(^{:m 1} [1 2])" {
+		t.Errorf("printed the meta as %v", printed)
+	} else if printed := (Options{Meta: true}).Pretty(tagged, 80, false); printed != "^{:m 1} [1 2]" {
+		t.Errorf("pretty printed the meta as %v", printed)
+	} else if printed := Print(tagged, true); printed != "[1 2]" {
+		t.Errorf("printed the meta without the option as %v", printed)
+	}
+	if printed := (Options{Length: Limit(2)}).Pretty(types.NewList(types.Symbol("do"), 1.0, 2.0), 80, true); printed != "(do 1 ...)" {
+		t.Errorf("pretty printed the code as %v", printed)
+	}
+}
+
+func TestPrintSelfContainingAtom(t *testing.T) {
+	atom := &types.Atom{}
+	atom.Val = types.NewVect(1.0, atom)
+	expected := "(atom [1 (atom ...)])"
+	if printed := Print(atom, true); printed != expected {
+		t.Errorf("Print(atom) = %v, expected %v", printed, expected)
+	} else if printed := Pretty(atom, 80, false); printed != expected {
+		t.Errorf("Pretty(atom) = %v, expected %v", printed, expected)
+	}
+	shared := &types.Atom{Val: 1.0}
+	if printed := Print(types.NewVect(shared, shared), true); printed != "[(atom 1) (atom 1)]" {
+		t.Errorf("printed an atom that appears twice as %v", printed)
+	}
+}
+
+// FuzzPrintRoundTrip checks that print(read(x)) is the normal form of x: it
+// reads back as the same form and printing it again does not change it. Pretty
+// printing it has to read back as the same form too.
+func FuzzPrintRoundTrip(f *testing.F) {
+	for _, seed := range []string{
+		"nil", "true", "-0", "1.50", "1e21", "123456789012345678901234",
+		`"a\"b\\c\nd"`, `"\t\a"`, ":kw", "sym", "(1 [2 {:a \"b\"}])",
+		"'(a `(b ~c ~@d))", "^:m [1]", "@x", "{}", "()", "[]",
+		`#"[0-9]+\s\"x\""`, `#"(?P<a>.)"`, `"\t\r\u00e9\0"`, `[\a \newline \( \u00e9 \é]`,
+		`{:b 1 :a 2 "c" 3 4 5 nil 6 [1] 7 [1] 8}`,
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		form, err := reader.ReadString(src)
+		if err != nil {
+			return
+		}
+		printed := Print(form, true)
+		again, err := reader.ReadString(printed)
+		if err != nil {
+			t.Fatalf("could not read %q printed from %q: %v", printed, src, err)
+		} else if !equalForms(form, again) {
+			t.Fatalf("%q printed as %q which reads as %q", src, printed, Print(again, true))
+		} else if Print(again, true) != printed {
+			t.Fatalf("%q printed as %q and then as %q", src, printed, Print(again, true))
+		}
+		for _, code := range []bool{false, true} {
+			pretty := Pretty(form, 10, code)
+			if again, err := reader.ReadString(pretty); err != nil || !equalForms(form, again) {
+				t.Fatalf("%q pretty printed as %q which reads as %q, %v", src, pretty, Print(again, true), err)
+			}
+		}
+	})
+}
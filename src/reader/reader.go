@@ -3,9 +3,13 @@ package reader
 import (
 	"errors"
 	"fmt"
+	"io"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/tanema/mal/src/types"
 )
@@ -13,19 +17,46 @@ import (
 var (
 	// ErrUnderflow is thrown when params are not matched
 	ErrUnderflow = errors.New("EOF underflow error: more input expected")
+	// ErrUnterminatedString is thrown when the input ends inside of a string
+	ErrUnterminatedString = errors.New("expected '\"', got EOF")
 
-	tokensPattern = regexp.MustCompile(`[\s,]*(~@|[\[\]{}()'` + "`" + `~^@]|"(?:\\.|[^\\"])*"?|;.*|[^\s\[\]{}('"` + "`" + `,;)]*)`)
-	numberPattern = regexp.MustCompile(`^-?[0-9]+\.?[0-9]*$`)
+	tokensPattern = regexp.MustCompile(`[\s,]*(~@|[\[\]{}()'` + "`" + `~^@]|#?"(?:\\.|[^\\"])*"?|;.*|\\\S[^\s\[\]{}('"` + "`" + `,;)]*|[^\s\[\]{}('"` + "`" + `,;)]*)`)
+	numberPattern = regexp.MustCompile(`^-?[0-9]+(\.[0-9]*)?([eE][-+]?[0-9]+)?$`)
 
-	stringEsc = map[string]string{
-		`\\`: `\`,
-		`\"`: `"`,
-		`\n`: "\n",
+	stringEsc = map[rune]rune{
+		'\\': '\\',
+		'"':  '"',
+		'n':  '\n',
+		't':  '\t',
+		'r':  '\r',
+		'b':  '\b',
+		'f':  '\f',
+		'0':  0,
 	}
 )
 
+// maxDepth is how deeply forms can be nested in the source that is read, so
+// that hostile data cannot overflow the stack
+const maxDepth = 10000
+
+// maxKeywords is how many keywords are kept for Keywords, so that reading a
+// lot of different keywords does not grow the table forever
+const maxKeywords = 10000
+
+var (
+	keywordsMu sync.Mutex
+	keywords   = map[types.Keyword]bool{}
+)
+
+// TagReader turns the form after a tag in EDN, like the string in
+// #inst "2024-10-18", into a value
+type TagReader func(tag types.Symbol, form types.Base) (types.Base, error)
+
 type reader struct {
 	tokens []string
+	edn    bool
+	tags   TagReader
+	depth  int
 }
 
 // ReadString will take in a source code string, tokenize it and then parse it,
@@ -35,6 +66,49 @@ func ReadString(in string) (types.Base, error) {
 	return rdr.form()
 }
 
+// ReadEDN reads the first form in the EDN source. Only data can be read, so
+// reader macros like quote and regex literals are errors, forms after #_ are
+// skipped and tagged literals are read with tags. It returns io.EOF if there
+// are no forms in the source.
+func ReadEDN(in string, tags TagReader) (types.Base, error) {
+	rdr := &reader{tokens: tokenize(in), edn: true, tags: tags}
+	if err := rdr.discard(); err != nil {
+		return nil, err
+	} else if len(rdr.tokens) == 0 {
+		return nil, io.EOF
+	}
+	return rdr.form()
+}
+
+// Keywords returns the keywords that have been read so far from source code,
+// up to the first 10000 different ones. Keywords read from EDN data are not
+// kept.
+func Keywords() []types.Keyword {
+	keywordsMu.Lock()
+	defer keywordsMu.Unlock()
+	result := make([]types.Keyword, 0, len(keywords))
+	for kw := range keywords {
+		result = append(result, kw)
+	}
+	return result
+}
+
+// ReadAll will read every form in the source string rather than just the first.
+// It is used when several forms are entered at once, like on a line in the REPL.
+// When a form cannot be read the forms before it are returned with the error.
+func ReadAll(in string) ([]types.Base, error) {
+	rdr := &reader{tokens: tokenize(in)}
+	forms := []types.Base{}
+	for len(rdr.tokens) > 0 {
+		form, err := rdr.form()
+		if err != nil {
+			return forms, err
+		}
+		forms = append(forms, form)
+	}
+	return forms, nil
+}
+
 func (rdr *reader) next() (string, bool) {
 	var token string
 	if len(rdr.tokens) == 0 {
@@ -63,11 +137,24 @@ func tokenize(in string) []string {
 }
 
 func (rdr *reader) form() (types.Base, error) {
+	if rdr.depth++; rdr.depth > maxDepth {
+		return nil, errors.New("forms are nested too deeply")
+	}
+	defer func() { rdr.depth-- }()
+	if err := rdr.discard(); err != nil {
+		return nil, err
+	}
 	token, hasNext := rdr.peek()
 	if !hasNext {
 		return nil, ErrUnderflow
 	}
 
+	if rdr.edn && strings.ContainsAny(token[:1], "'`~^@") {
+		return nil, fmt.Errorf("%v is not allowed in EDN", token)
+	} else if rdr.edn && token[0] == '#' {
+		return rdr.tagged()
+	}
+
 	switch token {
 	case `'`:
 		return rdr.modifier("quote")
@@ -114,6 +201,43 @@ func (rdr *reader) meta() (*types.List, error) {
 	return types.NewList(types.Symbol("with-meta"), form, meta), err
 }
 
+// discard skips the forms after #_ in EDN
+func (rdr *reader) discard() error {
+	for rdr.edn {
+		token, hasNext := rdr.peek()
+		if !hasNext || !strings.HasPrefix(token, "#_") {
+			return nil
+		} else if token == "#_" {
+			rdr.next()
+		} else {
+			rdr.tokens[0] = token[2:]
+		}
+		if _, err := rdr.form(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tagged reads a tagged literal in EDN, like #inst "2024-10-18", with the tag
+// reader
+func (rdr *reader) tagged() (types.Base, error) {
+	token, _ := rdr.next()
+	tag, _ := utf8.DecodeRuneInString(token[1:])
+	if next, _ := rdr.peek(); token == "#" && next == "{" {
+		return nil, errors.New("sets are not supported")
+	} else if !unicode.IsLetter(tag) {
+		return nil, fmt.Errorf("%v is not allowed in EDN", token)
+	}
+	form, err := rdr.form()
+	if err != nil {
+		return nil, err
+	} else if rdr.tags == nil {
+		return nil, fmt.Errorf("no reader for the tag %v", token)
+	}
+	return rdr.tags(types.Symbol(token[1:]), form)
+}
+
 func (rdr *reader) list(start, end string) (*types.List, error) {
 	list := &types.List{Forms: []types.Base{}}
 	token, hasNext := rdr.next()
@@ -123,8 +247,13 @@ func (rdr *reader) list(start, end string) (*types.List, error) {
 	if token != start {
 		return list, fmt.Errorf("unexpected '%v'", token)
 	}
-	token, hasNext = rdr.peek()
-	for ; token != end && hasNext; token, hasNext = rdr.peek() {
+	for {
+		if err := rdr.discard(); err != nil {
+			return list, err
+		}
+		if token, hasNext = rdr.peek(); token == end || !hasNext {
+			break
+		}
 		form, err := rdr.form()
 		if err != nil {
 			return list, err
@@ -165,30 +294,118 @@ func (rdr *reader) atom() (types.Base, error) {
 	} else if token == "false" {
 		return false, nil
 	} else if token[0] == ':' {
-		return types.Keyword(token[1:]), nil
+		if rdr.edn && strings.HasPrefix(token, "::") {
+			return nil, fmt.Errorf("%v is not allowed in EDN", token)
+		}
+		kw := types.Keyword(token[1:])
+		if !rdr.edn {
+			addKeyword(kw)
+		}
+		return kw, nil
 	} else if match := numberPattern.MatchString(token); match {
 		num, err := strconv.ParseFloat(token, 64)
 		if err != nil {
-			err = errors.New("improperly formatted number")
+			return nil, fmt.Errorf("number out of range %v", token)
 		}
 		return num, nil
+	} else if strings.HasPrefix(token, `#"`) {
+		if !terminated(token[1:]) {
+			return nil, ErrUnterminatedString
+		}
+		re, err := types.NewRegex(token[2 : len(token)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %v", err)
+		}
+		return re, nil
 	} else if token[0] == '"' {
-		if token[len(token)-1] != '"' {
-			return nil, errors.New("expected '\"', got EOF")
+		if !terminated(token) {
+			return nil, ErrUnterminatedString
 		}
-		str := token[1 : len(token)-1]
-		// for find, replace := range stringEsc {
-		//	str = strings.Replace(str, find, replace, -1)
-		// }
-		return strings.Replace(
-			strings.Replace(
-				strings.Replace(
-					strings.Replace(str, `\\`, "\u029e", -1),
-					`\"`, `"`, -1),
-				`\n`, "\n", -1),
-			"\u029e", "\\", -1), nil
-		//return str, nil
+		return unescape(token[1 : len(token)-1])
+	} else if token[0] == '\\' {
+		return char(token[1:])
 	}
 
 	return types.Symbol(token), nil
 }
+
+// addKeyword keeps the keyword for Keywords if there is room
+func addKeyword(kw types.Keyword) {
+	keywordsMu.Lock()
+	defer keywordsMu.Unlock()
+	if len(keywords) < maxKeywords {
+		keywords[kw] = true
+	}
+}
+
+// terminated checks that a string token ends with a closing quote that has not
+// been escaped
+func terminated(token string) bool {
+	if len(token) < 2 || token[len(token)-1] != '"' {
+		return false
+	}
+	escapes := 0
+	for i := len(token) - 2; i > 0 && token[i] == '\\'; i-- {
+		escapes++
+	}
+	return escapes%2 == 0
+}
+
+// unescape replaces the escapes in a string literal with the characters they
+// stand for. A backslash before any other character is kept as it is.
+func unescape(str string) (string, error) {
+	if !strings.ContainsRune(str, '\\') {
+		return str, nil
+	}
+	var out strings.Builder
+	for i := 0; i < len(str); i++ {
+		if str[i] != '\\' || i == len(str)-1 {
+			out.WriteByte(str[i])
+			continue
+		}
+		i++
+		esc, size := utf8.DecodeRuneInString(str[i:])
+		if ch, ok := stringEsc[esc]; ok {
+			out.WriteRune(ch)
+		} else if esc == 'u' {
+			if i+4 >= len(str) {
+				return "", errors.New("invalid unicode escape")
+			}
+			ch, err := unicodeEscape(str[i+1 : i+5])
+			if err != nil {
+				return "", err
+			}
+			out.WriteRune(ch)
+			i += 4
+		} else {
+			out.WriteByte('\\')
+			out.WriteString(str[i : i+size])
+			i += size - 1
+		}
+	}
+	return out.String(), nil
+}
+
+// char reads a character literal from the text after the backslash, like a,
+// newline or u00e9
+func char(name string) (types.Base, error) {
+	if ch, ok := types.CharNames[name]; ok {
+		return ch, nil
+	} else if utf8.RuneCountInString(name) == 1 {
+		ch, _ := utf8.DecodeRuneInString(name)
+		return types.Char(ch), nil
+	} else if len(name) == 5 && name[0] == 'u' {
+		ch, err := unicodeEscape(name[1:])
+		return types.Char(ch), err
+	}
+	return nil, fmt.Errorf("unsupported character \\%v", name)
+}
+
+// unicodeEscape reads the four hex digits of a \uXXXX escape
+func unicodeEscape(hex string) (rune, error) {
+	code, err := strconv.ParseUint(hex, 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid unicode escape \\u%v", hex)
+	}
+	return rune(code), nil
+}
@@ -0,0 +1,156 @@
+package reader
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/tanema/mal/src/printer"
+	"github.com/tanema/mal/src/types"
+)
+
+// readerSeeds are forms that cover each part of the syntax
+var readerSeeds = []string{
+	"",
+	"nil true false",
+	"1 -2 3.5 -0.25 007",
+	`"" "abc" "a\"b" "a\\b" "line\nbreak" "unterminated`,
+	"abc a/b :kw :ns/kw & -",
+	`"\t\r\u00e9\u12" "\q" \a \newline \space \u00e9 \é \( \ \bad`,
+	`#"[a-z]+\d" #"a\"b" #"(" #"unterminated`,
+	"(1 2 (3 [4 {:a 5}]))",
+	"[1 2 3",
+	"{:a 1 :b}",
+	"'a `(a ~b ~@c) @d ^{:m 1} [x]",
+	"; comment\n(+ 1 2) ; trailing",
+	")]}",
+	"((((((((((",
+	`#inst "2024-10-18" #_ 1 #_x [#_ #_ 2 3 4] #{1} #(x) ::kw #`,
+}
+
+func TestReadEscapes(t *testing.T) {
+	cases := map[string]types.Base{
+		`"a\tb\r\n"`:  "a\tb\r\n",
+		`"caf\u00e9"`: "café",
+		`"\\\"\0"`:    "\\\"\x00",
+		`"c:\dir"`:    `c:\dir`,
+		`\a`:          types.Char('a'),
+		`\newline`:    types.Char('\n'),
+		`\u00e9`:      types.Char('é'),
+		`\(`:          types.Char('('),
+	}
+	for src, expected := range cases {
+		if form, err := ReadString(src); err != nil || form != expected {
+			t.Errorf("ReadString(%v) = %q, %v, expected %q", src, form, err, expected)
+		}
+	}
+	for _, src := range []string{`"\u12"`, `"\uzzzz"`, `\bad`} {
+		if _, err := ReadString(src); err == nil {
+			t.Errorf("ReadString(%v) did not return an error", src)
+		}
+	}
+}
+
+func TestReadEDN(t *testing.T) {
+	tags := func(tag types.Symbol, form types.Base) (types.Base, error) {
+		return &types.Tagged{Tag: tag, Form: form}, nil
+	}
+	cases := map[string]string{
+		`{:a [1 "s" \c nil (x y)]}`: `{:a [1 "s" \c nil (x y)]}`,
+		`#_ 1 #_x 2`:                "2",
+		`[1 #_ #_ 2 3 4 #_5]`:       "[1 4]",
+		`#point {:x 1}`:             "#point {:x 1}",
+		`1 2`:                       "1",
+	}
+	for src, expected := range cases {
+		form, err := ReadEDN(src, tags)
+		if err != nil {
+			t.Errorf("ReadEDN(%v) returned %v", src, err)
+		} else if printed := printer.Print(form, true); printed != expected {
+			t.Errorf("ReadEDN(%v) = %v, expected %v", src, printed, expected)
+		}
+	}
+	for _, src := range []string{"'a", "`a", "[~a]", "@a", "^:m a", `#"re"`, "#{1}", "#(x)", "::kw", "#_", "#tag"} {
+		if _, err := ReadEDN(src, tags); err == nil {
+			t.Errorf("ReadEDN(%v) did not return an error", src)
+		}
+	}
+	if _, err := ReadEDN(" #_1 ", tags); err != io.EOF {
+		t.Errorf("ReadEDN of no forms returned %v, expected io.EOF", err)
+	}
+}
+
+func TestReadDepth(t *testing.T) {
+	nested := strings.Repeat("[", maxDepth) + strings.Repeat("]", maxDepth)
+	if _, err := ReadEDN(nested, nil); err != nil {
+		t.Errorf("ReadEDN of %v nested vectors returned %v", maxDepth, err)
+	}
+	for _, src := range []string{
+		strings.Repeat("[", maxDepth+1),
+		strings.Repeat("[", maxDepth+1) + strings.Repeat("]", maxDepth+1),
+		strings.Repeat("#_", maxDepth+1) + "1",
+	} {
+		if _, err := ReadEDN(src, nil); err == nil || err == ErrUnderflow {
+			t.Errorf("ReadEDN of %v nested forms returned %v, expected too deep", len(src), err)
+		}
+	}
+	if _, err := ReadString(strings.Repeat("'", maxDepth+1) + "a"); err == nil {
+		t.Errorf("ReadString of %v quotes did not return an error", maxDepth+1)
+	}
+}
+
+func TestReadAllError(t *testing.T) {
+	cases := map[string]error{
+		"1 (+ 2 3) )":     nil,
+		"1 (+ 2 3) (":     ErrUnderflow,
+		`1 (+ 2 3) "a`:    ErrUnterminatedString,
+		`1 (+ 2 3) #"a\d`: nil,
+	}
+	for src, expected := range cases {
+		forms, err := ReadAll(src)
+		if err == nil || (expected != nil && err != expected) {
+			t.Errorf("ReadAll(%v) returned %v, expected %v", src, err, expected)
+		} else if got := printer.List(forms, true, "", "", " "); got != "1 (+ 2 3)" {
+			t.Errorf("ReadAll(%v) returned the forms %v before the error, expected 1 (+ 2 3)", src, got)
+		}
+	}
+}
+
+func TestKeywords(t *testing.T) {
+	hasKeyword := func(name types.Keyword) bool {
+		for _, kw := range Keywords() {
+			if kw == name {
+				return true
+			}
+		}
+		return false
+	}
+	ReadString(":from-code")
+	ReadEDN(":from-edn", nil)
+	if !hasKeyword("from-code") {
+		t.Errorf("the keyword read from code is not in Keywords()")
+	} else if hasKeyword("from-edn") {
+		t.Errorf("the keyword read from EDN is in Keywords()")
+	}
+	for i := 0; i <= maxKeywords; i++ {
+		ReadString(":kw" + strconv.Itoa(i))
+	}
+	if count := len(Keywords()); count != maxKeywords {
+		t.Errorf("Keywords() has %v keywords, expected at most %v", count, maxKeywords)
+	}
+}
+
+func FuzzReadAll(f *testing.F) {
+	for _, seed := range readerSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		forms, err := ReadAll(src)
+		if err == nil && forms == nil {
+			t.Fatalf("ReadAll(%q) returned no forms and no error", src)
+		}
+		ReadString(src)
+		ReadEDN(src, nil)
+	})
+}
@@ -0,0 +1,105 @@
+package readline
+
+import (
+	"sort"
+	"strings"
+)
+
+// Completer is used to find completions when tab is pressed. It is given the
+// line and the cursor position and returns where the word being completed starts
+// along with every candidate that could replace it.
+type Completer func(line []rune, pos int) (start int, candidates []string)
+
+// Documenter returns help text for whatever is under the cursor. The text is
+// shown below the line when Alt-h or F1 is pressed.
+type Documenter func(line []rune, pos int) string
+
+var completer Completer
+var documenter Documenter
+
+// SetCompleter sets the function used to complete words when tab is pressed
+func SetCompleter(fn Completer) {
+	completer = fn
+}
+
+// SetDocumenter sets the function used to look up help for the word at the cursor
+func SetDocumenter(fn Documenter) {
+	documenter = fn
+}
+
+// complete will insert the longest prefix shared by all the candidates. If
+// that does not add anything a bell is rung, and on a repeated press all of
+// the candidates are listed below the line.
+func (ed *editor) complete(repeated bool) {
+	if completer == nil {
+		return
+	}
+	start, candidates := completer(ed.buf, ed.pos)
+	if len(candidates) == 0 || start < 0 || start > ed.pos {
+		ed.write("\a")
+		return
+	}
+	word := ed.buf[start:ed.pos]
+	prefix := []rune(commonPrefix(candidates))
+	if len(prefix) > len(word) {
+		ed.deleteRange(start, ed.pos)
+		ed.insert(prefix...)
+	} else if repeated {
+		ed.showList(candidates)
+	} else {
+		ed.write("\a")
+	}
+}
+
+func commonPrefix(candidates []string) string {
+	prefix := []rune(candidates[0])
+	for _, candidate := range candidates[1:] {
+		runes := []rune(candidate)
+		i := 0
+		for i < len(prefix) && i < len(runes) && prefix[i] == runes[i] {
+			i++
+		}
+		prefix = prefix[:i]
+	}
+	return string(prefix)
+}
+
+// showList prints the candidates in columns below the line being edited
+func (ed *editor) showList(candidates []string) {
+	sorted := append([]string{}, candidates...)
+	sort.Strings(sorted)
+	colWidth := 0
+	for _, candidate := range sorted {
+		if w := width([]rune(candidate)) + 2; w > colWidth {
+			colWidth = w
+		}
+	}
+	perRow := ed.cols() / colWidth
+	if perRow < 1 {
+		perRow = 1
+	}
+	var out strings.Builder
+	for i, candidate := range sorted {
+		if i%perRow == 0 {
+			out.WriteString("\r\n")
+		}
+		out.WriteString(candidate)
+		if i%perRow != perRow-1 {
+			out.WriteString(strings.Repeat(" ", colWidth-width([]rune(candidate))))
+		}
+	}
+	ed.write(out.String() + "\r\n")
+}
+
+// showDoc prints the help for the word at the cursor below the line
+func (ed *editor) showDoc() {
+	if documenter == nil {
+		return
+	}
+	text := strings.TrimRight(documenter(ed.buf, ed.pos), "\n")
+	if text == "" {
+		ed.write("\a")
+		return
+	}
+	ed.write("\r\n" + strings.Replace(text, "\n", "\r\n", -1) + "\r\n")
+}
@@ -0,0 +1,437 @@
+package readline
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+type key rune
+
+const (
+	ctrlA     key = 1
+	ctrlB     key = 2
+	ctrlC     key = 3
+	ctrlD     key = 4
+	ctrlE     key = 5
+	ctrlF     key = 6
+	ctrlG     key = 7
+	ctrlH     key = 8
+	tab       key = 9
+	ctrlJ     key = 10
+	ctrlK     key = 11
+	ctrlL     key = 12
+	enter     key = 13
+	ctrlN     key = 14
+	ctrlP     key = 16
+	ctrlR     key = 18
+	ctrlT     key = 20
+	ctrlU     key = 21
+	ctrlW     key = 23
+	ctrlY     key = 25
+	esc       key = 27
+	backspace key = 127
+)
+
+// special keys are given values past the end of the unicode range so that they
+// cannot be mistaken for typed characters
+const (
+	keyUnknown key = unicode.MaxRune + 1 + iota
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyDelete
+	keyWordLeft
+	keyWordRight
+	keyDeleteWord
+	keyBackspaceWord
+	keyHelp
+)
+
+type editor struct {
+	prompt  string
+	buf     []rune
+	pos     int
+	in      *bufio.Reader
+	out     io.Writer
+	hist    []string
+	histIdx int
+	saved   []rune
+	yank    []rune
+}
+
+func (ed *editor) run() (string, error) {
+	ed.histIdx = len(ed.hist)
+	ed.refresh()
+	var pending, last key
+	for {
+		k := pending
+		pending = 0
+		if k == 0 {
+			var err error
+			if k, err = ed.readKey(); err != nil {
+				ed.write("\r\n")
+				return "", err
+			}
+		}
+		repeated := k == last
+		last = k
+		switch k {
+		case enter, ctrlJ:
+			ed.pos = len(ed.buf)
+			ed.refresh()
+			ed.write("\r\n")
+			return string(ed.buf), nil
+		case ctrlC:
+			ed.write("^C\r\n")
+			return "", ErrInterrupt
+		case ctrlD:
+			if len(ed.buf) == 0 {
+				ed.write("\r\n")
+				return "", io.EOF
+			}
+			ed.deleteRange(ed.pos, ed.pos+1)
+		case ctrlA, keyHome:
+			ed.pos = 0
+		case ctrlE, keyEnd:
+			ed.pos = len(ed.buf)
+		case ctrlB, keyLeft:
+			if ed.pos > 0 {
+				ed.pos--
+			}
+		case ctrlF, keyRight:
+			if ed.pos < len(ed.buf) {
+				ed.pos++
+			}
+		case keyWordLeft:
+			ed.pos = ed.wordStart()
+		case keyWordRight:
+			ed.pos = ed.wordEnd()
+		case ctrlH, backspace:
+			if ed.pos > 0 {
+				ed.deleteRange(ed.pos-1, ed.pos)
+			}
+		case keyDelete:
+			ed.deleteRange(ed.pos, ed.pos+1)
+		case ctrlK:
+			ed.kill(ed.pos, len(ed.buf))
+		case ctrlU:
+			ed.kill(0, ed.pos)
+		case ctrlW, keyBackspaceWord:
+			ed.kill(ed.wordStart(), ed.pos)
+		case keyDeleteWord:
+			ed.kill(ed.pos, ed.wordEnd())
+		case ctrlY:
+			ed.insert(ed.yank...)
+		case ctrlT:
+			ed.transpose()
+		case ctrlL:
+			ed.write("\x1b[H\x1b[2J")
+		case ctrlP, keyUp:
+			ed.historyMove(-1)
+		case ctrlN, keyDown:
+			ed.historyMove(1)
+		case ctrlR:
+			pending = ed.reverseSearch()
+		case tab:
+			ed.complete(repeated)
+		case keyHelp:
+			ed.showDoc()
+		default:
+			if k < keyUnknown && unicode.IsPrint(rune(k)) {
+				ed.insert(rune(k))
+			}
+		}
+		ed.refresh()
+	}
+}
+
+func (ed *editor) write(s string) {
+	io.WriteString(ed.out, s)
+}
+
+func (ed *editor) readKey() (key, error) {
+	r, _, err := ed.in.ReadRune()
+	if err != nil {
+		return 0, err
+	} else if key(r) != esc {
+		return key(r), nil
+	} else if ed.in.Buffered() == 0 {
+		// terminals send the rest of an escape sequence along with the escape,
+		// so an escape with nothing after it was pressed on its own. Reading on
+		// would wait for the next key.
+		return esc, nil
+	}
+	if r, _, err = ed.in.ReadRune(); err != nil {
+		return 0, err
+	}
+	switch r {
+	case '[', 'O':
+		return ed.readSequence()
+	case 'b', 'B':
+		return keyWordLeft, nil
+	case 'f', 'F':
+		return keyWordRight, nil
+	case 'd', 'D':
+		return keyDeleteWord, nil
+	case rune(ctrlH), rune(backspace):
+		return keyBackspaceWord, nil
+	case 'h', 'H':
+		return keyHelp, nil
+	default:
+		return keyUnknown, nil
+	}
+}
+
+// readSequence decodes the ANSI escape sequences sent by the arrow, home, end,
+// delete and F1 keys.
+func (ed *editor) readSequence() (key, error) {
+	params := []rune{}
+	for {
+		r, _, err := ed.in.ReadRune()
+		if err != nil {
+			return 0, err
+		}
+		if r < 0x40 || r > 0x7e {
+			params = append(params, r)
+			continue
+		}
+		modified := strings.HasSuffix(string(params), ";5") || strings.HasSuffix(string(params), ";3")
+		switch r {
+		case 'A':
+			return keyUp, nil
+		case 'B':
+			return keyDown, nil
+		case 'C':
+			if modified {
+				return keyWordRight, nil
+			}
+			return keyRight, nil
+		case 'D':
+			if modified {
+				return keyWordLeft, nil
+			}
+			return keyLeft, nil
+		case 'H':
+			return keyHome, nil
+		case 'F':
+			return keyEnd, nil
+		case 'P':
+			return keyHelp, nil
+		case '~':
+			switch string(params) {
+			case "11":
+				return keyHelp, nil
+			case "1", "7":
+				return keyHome, nil
+			case "4", "8":
+				return keyEnd, nil
+			case "3":
+				return keyDelete, nil
+			}
+		}
+		return keyUnknown, nil
+	}
+}
+
+func (ed *editor) insert(runes ...rune) {
+	buf := make([]rune, 0, len(ed.buf)+len(runes))
+	buf = append(buf, ed.buf[:ed.pos]...)
+	buf = append(buf, runes...)
+	ed.buf = append(buf, ed.buf[ed.pos:]...)
+	ed.pos += len(runes)
+}
+
+func (ed *editor) deleteRange(from, to int) {
+	if from < 0 || to > len(ed.buf) || from >= to {
+		return
+	}
+	ed.buf = append(ed.buf[:from], ed.buf[to:]...)
+	if ed.pos > to {
+		ed.pos -= to - from
+	} else if ed.pos > from {
+		ed.pos = from
+	}
+}
+
+func (ed *editor) kill(from, to int) {
+	if from >= to {
+		return
+	}
+	ed.yank = append([]rune{}, ed.buf[from:to]...)
+	ed.deleteRange(from, to)
+}
+
+func (ed *editor) transpose() {
+	if ed.pos == 0 || len(ed.buf) < 2 {
+		return
+	}
+	if ed.pos == len(ed.buf) {
+		ed.pos--
+	}
+	ed.buf[ed.pos-1], ed.buf[ed.pos] = ed.buf[ed.pos], ed.buf[ed.pos-1]
+	ed.pos++
+}
+
+func isWordRune(r rune) bool {
+	return !unicode.IsSpace(r) && !strings.ContainsRune("()[]{}\"'`~@^,;", r)
+}
+
+func (ed *editor) wordStart() int {
+	i := ed.pos
+	for i > 0 && !isWordRune(ed.buf[i-1]) {
+		i--
+	}
+	for i > 0 && isWordRune(ed.buf[i-1]) {
+		i--
+	}
+	return i
+}
+
+func (ed *editor) wordEnd() int {
+	i := ed.pos
+	for i < len(ed.buf) && !isWordRune(ed.buf[i]) {
+		i++
+	}
+	for i < len(ed.buf) && isWordRune(ed.buf[i]) {
+		i++
+	}
+	return i
+}
+
+func (ed *editor) setLine(line string) {
+	ed.buf = []rune(line)
+	ed.pos = len(ed.buf)
+}
+
+func (ed *editor) historyMove(dir int) {
+	idx := ed.histIdx + dir
+	if idx < 0 || idx > len(ed.hist) {
+		return
+	}
+	if ed.histIdx == len(ed.hist) {
+		ed.saved = append([]rune{}, ed.buf...)
+	}
+	ed.histIdx = idx
+	if idx == len(ed.hist) {
+		ed.setLine(string(ed.saved))
+	} else {
+		ed.setLine(ed.hist[idx])
+	}
+}
+
+// reverseSearch runs an incremental search backwards through the history. The
+// found line is accepted with any key that is not part of the search and that
+// key is returned so that it can be handled as normal. Ctrl-G, Ctrl-C and escape
+// cancel the search and put back the line from before it.
+func (ed *editor) reverseSearch() key {
+	original, originalPos := ed.buf, ed.pos
+	query := []rune{}
+	idx, failed := len(ed.hist), false
+	search := func(from int) {
+		for i := from; i >= 0 && i < len(ed.hist); i-- {
+			if at := strings.Index(ed.hist[i], string(query)); at >= 0 {
+				idx, failed = i, false
+				ed.setLine(ed.hist[i])
+				ed.pos = len([]rune(ed.hist[i][:at]))
+				return
+			}
+		}
+		failed = true
+	}
+	prompt := ed.prompt
+	defer func() { ed.prompt = prompt }()
+	for {
+		status := "reverse-i-search"
+		if failed {
+			status = "failed " + status
+		}
+		ed.prompt = fmt.Sprintf("(%v)`%v': ", status, string(query))
+		ed.refresh()
+		k, err := ed.readKey()
+		if err != nil {
+			return ctrlD
+		}
+		switch {
+		case k == ctrlR:
+			search(idx - 1)
+		case k == ctrlH || k == backspace:
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				search(len(ed.hist) - 1)
+			}
+		case k == ctrlG || k == ctrlC || k == esc:
+			ed.buf, ed.pos = original, originalPos
+			return 0
+		case k < keyUnknown && unicode.IsPrint(rune(k)):
+			query = append(query, rune(k))
+			if idx == len(ed.hist) {
+				search(idx - 1)
+			} else {
+				search(idx)
+			}
+		default:
+			ed.histIdx = len(ed.hist)
+			return k
+		}
+	}
+}
+
+// refresh redraws the prompt and the line, scrolling the line horizontally when
+// it does not fit in the terminal.
+func (ed *editor) refresh() {
+	cols := ed.cols()
+	promptWidth := width([]rune(ed.prompt))
+	start, end := 0, len(ed.buf)
+	for start < ed.pos && promptWidth+width(ed.buf[start:ed.pos]) >= cols {
+		start++
+	}
+	for end > ed.pos && promptWidth+width(ed.buf[start:end]) >= cols {
+		end--
+	}
+	var out strings.Builder
+	out.WriteString("\r" + ed.prompt + string(ed.buf[start:end]) + "\x1b[0K\r")
+	if col := promptWidth + width(ed.buf[start:ed.pos]); col > 0 {
+		fmt.Fprintf(&out, "\x1b[%vC", col)
+	}
+	ed.write(out.String())
+}
+
+func (ed *editor) cols() int {
+	return termWidth(os.Stdout.Fd())
+}
+
+// width calculates how many terminal columns the runes will take up. Combining
+// marks take no space while wide east asian characters take two.
+func width(runes []rune) int {
+	total := 0
+	for _, r := range runes {
+		switch {
+		case unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Me, r):
+		case isWide(r):
+			total += 2
+		default:
+			total++
+		}
+	}
+	return total
+}
+
+func isWide(r rune) bool {
+	return (r >= 0x1100 && r <= 0x115f) ||
+		(r >= 0x2e80 && r <= 0xa4cf && r != 0x303f) ||
+		(r >= 0xac00 && r <= 0xd7a3) ||
+		(r >= 0xf900 && r <= 0xfaff) ||
+		(r >= 0xfe30 && r <= 0xfe4f) ||
+		(r >= 0xff00 && r <= 0xff60) ||
+		(r >= 0xffe0 && r <= 0xffe6) ||
+		(r >= 0x1f300 && r <= 0x1f64f) ||
+		(r >= 0x1f900 && r <= 0x1f9ff) ||
+		(r >= 0x20000 && r <= 0x3fffd)
+}
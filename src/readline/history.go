@@ -0,0 +1,73 @@
+package readline
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// history keeps the previously entered lines in memory and appends each new
+// line to the history file so that it is available to the next session. Once
+// the file has more than max lines it is rewritten with only the last max.
+type history struct {
+	path      string
+	lines     []string
+	max       int
+	fileLines int
+}
+
+func (h *history) load(path string) error {
+	h.path = path
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if line == "" {
+			continue
+		}
+		h.fileLines++
+		h.push(line)
+	}
+	return nil
+}
+
+func (h *history) push(line string) bool {
+	if strings.TrimSpace(line) == "" {
+		return false
+	} else if len(h.lines) > 0 && h.lines[len(h.lines)-1] == line {
+		return false
+	}
+	h.lines = append(h.lines, line)
+	if len(h.lines) > h.max {
+		h.lines = h.lines[len(h.lines)-h.max:]
+	}
+	return true
+}
+
+func (h *history) add(line string) {
+	if !h.push(line) || h.path == "" {
+		return
+	}
+	if h.fileLines++; h.fileLines > h.max {
+		if err := h.save(); err != nil {
+			fmt.Fprintln(os.Stderr, "error writing to history")
+		}
+		return
+	}
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		fmt.Fprintln(os.Stderr, "error writing to history")
+	}
+}
+
+// save replaces the history file with the lines kept in memory
+func (h *history) save() error {
+	h.fileLines = len(h.lines)
+	return ioutil.WriteFile(h.path, []byte(strings.Join(h.lines, "\n")+"\n"), 0600)
+}
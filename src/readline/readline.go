@@ -1,75 +1,96 @@
+// Package readline is a small pure Go line editor with emacs style keybindings
+// and a persistent history. When stdin is not an interactive terminal it falls
+// back to plain line reading.
 package readline
 
-/*
-// IMPORTANT: choose one
-#cgo LDFLAGS: -ledit
-//#cgo LDFLAGS: -lreadline // NOTE: libreadline is GPL
-
-// free()
-#include <stdlib.h>
-// readline()
-#include <stdio.h> // FILE *
-#include <readline/readline.h>
-// add_history()
-#include <readline/history.h>
-*/
-import "C"
-
 import (
+	"bufio"
 	"errors"
-	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
-	"unsafe"
 )
 
+// ErrInterrupt is returned when the user presses Ctrl-C while editing a line
+var ErrInterrupt = errors.New("interrupt")
+
 var histFile = ".mal-history"
 var historyPath string
+var hist = &history{max: 1000}
+var stdin = bufio.NewReader(os.Stdin)
+var customInput bool
 
 func init() {
 	historyPath = filepath.Join(os.Getenv("HOME"), histFile)
-	loadHistory(historyPath)
+	hist.load(historyPath)
 }
 
-func loadHistory(filename string) error {
-	content, err := ioutil.ReadFile(historyPath)
+// Readline will read in a single line of text with the provided prompt. When
+// the input is closed, or Ctrl-D is pressed on an empty line, io.EOF is returned.
+// Pressing Ctrl-C will abandon the line and return ErrInterrupt.
+func Readline(prompt string) (string, error) {
+	interactive := !customInput && isTerminal(os.Stdin.Fd())
+	var line string
+	var err error
+	if interactive && supportedTerm() {
+		line, err = editLine(prompt)
+	} else {
+		line, err = readPlain(prompt)
+	}
 	if err != nil {
-		return err
+		return "", err
+	}
+	if interactive {
+		hist.add(line)
 	}
+	return line, nil
+}
 
-	for _, addLine := range strings.Split(string(content), "\n") {
-		if addLine == "" {
-			continue
-		}
-		cAddLine := C.CString(addLine)
-		C.add_history(cAddLine)
-		C.free(unsafe.Pointer(cAddLine))
+// SetInput makes Readline read plain lines from r instead of stdin, without
+// editing or history. Passing nil goes back to reading from stdin.
+func SetInput(r io.Reader) {
+	customInput = r != nil
+	if r == nil {
+		r = os.Stdin
 	}
+	stdin = bufio.NewReader(r)
+}
 
-	return nil
+func readPlain(prompt string) (string, error) {
+	os.Stdout.WriteString(prompt)
+	line, err := stdin.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return "", io.EOF
+	} else if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
 }
 
-// Readline will read in a single line of text with the provided prompt
-func Readline(prompt string) (string, error) {
-	cPrompt := C.CString(prompt)
-	defer C.free(unsafe.Pointer(cPrompt))
-	cLine := C.readline(cPrompt)
-	defer C.free(unsafe.Pointer(cLine))
-	if cLine == nil {
-		return "", errors.New("C.readline call failed")
+func editLine(prompt string) (string, error) {
+	fd := os.Stdin.Fd()
+	state, err := makeRaw(fd)
+	if err != nil {
+		return readPlain(prompt)
 	}
-	C.add_history(cLine)
-	line := C.GoString(cLine)
-	// append to file
-	f, e := os.OpenFile(historyPath, os.O_APPEND|os.O_WRONLY, 0600)
-	if e == nil {
-		defer f.Close()
-		_, e = f.WriteString(line + "\n")
-		if e != nil {
-			fmt.Printf("error writing to history")
-		}
+	defer restore(fd, state)
+	ed := &editor{
+		prompt: prompt,
+		in:     stdin,
+		out:    os.Stdout,
+		hist:   hist.lines,
+	}
+	return ed.run()
+}
+
+// supportedTerm checks the TERM variable for terminals that cannot handle the
+// escape sequences used to redraw the line.
+func supportedTerm() bool {
+	switch strings.ToLower(os.Getenv("TERM")) {
+	case "dumb", "cons25", "emacs":
+		return false
+	default:
+		return true
 	}
-	return line, nil
 }
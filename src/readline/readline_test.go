@@ -0,0 +1,148 @@
+package readline
+
+import (
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// edit runs the line editor on the input given to SetInput. Each string in
+// chunks is returned by a separate read, like keys typed one after another.
+func edit(hist []string, chunks ...string) (string, error) {
+	readers := make([]io.Reader, len(chunks))
+	for i, chunk := range chunks {
+		readers[i] = strings.NewReader(chunk)
+	}
+	SetInput(io.MultiReader(readers...))
+	defer SetInput(nil)
+	ed := &editor{prompt: "> ", in: stdin, out: ioutil.Discard, hist: hist}
+	return ed.run()
+}
+
+func TestKeyBindings(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    []string
+		expected string
+	}{
+		{"typing", []string{"abc\r"}, "abc"},
+		{"ctrl-j", []string{"abc\n"}, "abc"},
+		{"ctrl-a", []string{"bc\x01a\r"}, "abc"},
+		{"ctrl-e", []string{"bc\x01a\x05d\r"}, "abcd"},
+		{"ctrl-b", []string{"ac\x02b\r"}, "abc"},
+		{"ctrl-f", []string{"ac\x01\x06b\r"}, "abc"},
+		{"arrows", []string{"ac\x1b[Db\x1b[Cd\r"}, "abcd"},
+		{"home and end", []string{"b\x1b[Ha\x1b[Fc\r"}, "abc"},
+		{"home and end with ~", []string{"b\x1b[1~a\x1b[4~c\r"}, "abc"},
+		{"backspace", []string{"abx\x7fc\r"}, "abc"},
+		{"ctrl-h", []string{"abx\x08c\r"}, "abc"},
+		{"ctrl-d deletes", []string{"abxc\x02\x02\x04\r"}, "abc"},
+		{"delete", []string{"abxc\x02\x02\x1b[3~\r"}, "abc"},
+		{"ctrl-k and ctrl-y", []string{"abc\x02\x02\x0b\x01\x19\r"}, "bca"},
+		{"ctrl-u", []string{"xy abc\x02\x02\x02\x15\x05\r"}, "abc"},
+		{"ctrl-w", []string{"abc def\x17\r"}, "abc "},
+		{"alt-backspace", []string{"(abc def\x1b\x7f\r"}, "(abc "},
+		{"alt-b and alt-f", []string{"ab cd\x1bb\x1bbx\x1bf\x1bfy\r"}, "xab cdy"},
+		{"ctrl-arrows", []string{"ab cd\x1b[1;5Dx\x1b[1;5Cy\r"}, "ab xcdy"},
+		{"alt-d", []string{"ab cd\x01\x1bd\r"}, " cd"},
+		{"ctrl-t", []string{"acb\x02\x14\r"}, "abc"},
+		{"ctrl-t at the end", []string{"acb\x14\r"}, "abc"},
+		{"unknown sequence", []string{"a\x1b[9Zb\r"}, "ab"},
+		{"escape on its own", []string{"ab\x1b", "c\r"}, "abc"},
+		{"ctrl-l", []string{"ab\x0cc\r"}, "abc"},
+		{"unicode", []string{"λ\x02x\r"}, "xλ"},
+	}
+	for _, c := range cases {
+		if line, err := edit(nil, c.input...); err != nil || line != c.expected {
+			t.Errorf("%v: %q returned %q, %v, expected %q", c.name, strings.Join(c.input, ""), line, err, c.expected)
+		}
+	}
+}
+
+func TestEndOfInput(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected error
+	}{
+		{"ctrl-c", "abc\x03", ErrInterrupt},
+		{"ctrl-d on an empty line", "\x04", io.EOF},
+		{"end of the input", "abc", io.EOF},
+		{"end of the input in a sequence", "abc\x1b[", io.EOF},
+	}
+	for _, c := range cases {
+		if line, err := edit(nil, c.input); err != c.expected || line != "" {
+			t.Errorf("%v: %q returned %q, %v, expected %v", c.name, c.input, line, err, c.expected)
+		}
+	}
+}
+
+func TestHistoryNavigation(t *testing.T) {
+	hist := []string{"one", "two", "three"}
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"ctrl-p", "\x10\r", "three"},
+		{"ctrl-p twice", "\x10\x10\r", "two"},
+		{"past the start", "\x10\x10\x10\x10\x10\r", "one"},
+		{"ctrl-n", "\x10\x10\x0e\r", "three"},
+		{"back to the line", "on\x10\x10\x0e\x0e\r", "on"},
+		{"past the end", "on\x0e\r", "on"},
+		{"arrows", "\x1b[A\x1b[A\x1b[B\r", "three"},
+		{"editing a history line", "\x10\x10\x7f\x7fo\r", "to"},
+	}
+	for _, c := range cases {
+		if line, err := edit(hist, c.input); err != nil || line != c.expected {
+			t.Errorf("%v: %q returned %q, %v, expected %q", c.name, c.input, line, err, c.expected)
+		}
+	}
+}
+
+func TestReverseSearch(t *testing.T) {
+	hist := []string{"(def! a 1)", "(+ 1 2)", "(def! b 2)"}
+	cases := []struct {
+		name     string
+		input    []string
+		expected string
+	}{
+		{"latest match", []string{"\x12def\r"}, "(def! b 2)"},
+		{"ctrl-r again", []string{"\x12def\x12\r"}, "(def! a 1)"},
+		{"failed search keeps the match", []string{"\x12d\x12ef! b\r"}, "(def! a 1)"},
+		{"no match", []string{"x\x12zzz\r"}, "x"},
+		{"backspace", []string{"\x12+ 1\x7f\x7f\x7f\x7f(\r"}, "(def! b 2)"},
+		{"ctrl-g", []string{"x\x12def\x07\r"}, "x"},
+		{"ctrl-c", []string{"x\x12def\x03\r"}, "x"},
+		{"escape", []string{"x\x12def\x1b", "\r"}, "x"},
+		{"accepting with a key", []string{"\x12+\x05!\r"}, "(+ 1 2)!"},
+		{"cursor at the match", []string{"\x12+\x06\x06x\r"}, "(+ x1 2)"},
+	}
+	for _, c := range cases {
+		if line, err := edit(hist, c.input...); err != nil || line != c.expected {
+			t.Errorf("%v: %q returned %q, %v, expected %q", c.name, strings.Join(c.input, ""), line, err, c.expected)
+		}
+	}
+}
+
+func TestHistoryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	if err := ioutil.WriteFile(path, []byte("a\nb\n\nc\nd\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	h := &history{max: 3}
+	if err := h.load(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(h.lines, " "); got != "b c d" {
+		t.Errorf("loaded %q, expected the last 3 lines", got)
+	}
+	for _, line := range []string{"e", "e", " ", "f"} {
+		h.add(line)
+	}
+	if content, err := ioutil.ReadFile(path); err != nil || string(content) != "d\ne\nf\n" {
+		t.Errorf("history file is %q, %v, expected the last 3 lines", content, err)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build linux
+// +build linux
+
+package readline
+
+import "syscall"
+
+const (
+	ioctlGetTermios = syscall.TCGETS
+	ioctlSetTermios = syscall.TCSETS
+)
@@ -0,0 +1,18 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package readline
+
+import "errors"
+
+type termState struct{}
+
+func isTerminal(fd uintptr) bool { return false }
+
+func makeRaw(fd uintptr) (*termState, error) {
+	return nil, errors.New("line editing is not supported on this platform")
+}
+
+func restore(fd uintptr, state *termState) error { return nil }
+
+func termWidth(fd uintptr) int { return 80 }
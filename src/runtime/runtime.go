@@ -1,15 +1,83 @@
 package runtime
 
 import (
+	"errors"
 	"fmt"
 
+	"github.com/tanema/mal/src/env"
 	"github.com/tanema/mal/src/types"
 )
 
+// SpecialForms are the symbols that are handled by the evaluator itself rather
+// than being looked up in the environment
+var SpecialForms = []types.Symbol{
+	"def!", "defmacro!", "let*", "fn*", "do", "if", "quote", "quasiquote",
+	"unquote", "splice-unquote", "macroexpand", "try*", "catch*",
+}
+
+// ErrStepLimit is returned when evaluation takes more steps than the limit set
+// with SetStepLimit
+var ErrStepLimit = errors.New("step limit exceeded")
+
+// SetStepLimit limits the number of steps that evaluation in the interpreter
+// that e belongs to can take before it fails with ErrStepLimit, so that
+// untrusted code cannot run forever. Every form that Eval evaluates is a step,
+// and so is every item of a collection or 64 bytes of a string built by a
+// builtin function so that it cannot use up all of the memory either. A limit of
+// 0 removes the limit. Setting the limit starts the count again. Each
+// interpreter has a limit and count of its own.
+func SetStepLimit(e types.Env, limit int64) {
+	if registry := registryOf(e); registry != nil {
+		registry.SetStepLimit(limit)
+	}
+}
+
+// StepLimited checks if a step limit has been set for the interpreter that e
+// belongs to
+func StepLimited(e types.Env) bool {
+	registry := registryOf(e)
+	return registry != nil && registry.StepLimited()
+}
+
+// Charge counts n steps towards the step limit of the interpreter that e belongs
+// to. Builtin functions use it for work that grows with the size of their
+// arguments rather than the code that calls them.
+func Charge(e types.Env, n int64) error {
+	if registry := registryOf(e); registry != nil && !registry.Step(n) {
+		return ErrStepLimit
+	}
+	return nil
+}
+
+func registryOf(e types.Env) *env.Registry {
+	if tenv, ok := e.(*env.Env); ok {
+		return tenv.Registry()
+	}
+	return nil
+}
+
+func chargeSize(e types.Env, val types.Base) error {
+	switch tval := val.(type) {
+	case string:
+		return Charge(e, int64(len(tval)/64))
+	case *types.List:
+		return Charge(e, int64(len(tval.Forms)))
+	case *types.Vector:
+		return Charge(e, int64(len(tval.Forms)))
+	case *types.Hashmap:
+		return Charge(e, int64(len(tval.Forms)))
+	default:
+		return nil
+	}
+}
+
 // Eval will take in an AST and evaluate it, executing each command
 func Eval(e types.Env, object types.Base) (types.Base, error) {
 	var err error
 	for {
+		if err := Charge(e, 1); err != nil {
+			return nil, err
+		}
 		object, err = macroExpand(e, object)
 		if err != nil {
 			return nil, err
@@ -23,13 +91,16 @@ func Eval(e types.Env, object types.Base) (types.Base, error) {
 			sym, _ := tobject.Forms[0].(types.Symbol)
 			switch sym {
 			case "try*":
-				object, err = evalTry(e, tobject.Forms[1:]...)
+				return evalTry(e, tobject.Forms[1:]...)
 			case "quote":
 				if len(tobject.Forms) < 2 {
 					return nil, nil
 				}
 				return tobject.Forms[1], nil
 			case "quasiquote":
+				if len(tobject.Forms) < 2 {
+					return nil, nil
+				}
 				object = evalQuasiQuote(e, tobject.Forms[1])
 			case "do":
 				object, err = evalDo(e, tobject.Forms[1:]...)
@@ -38,6 +109,9 @@ func Eval(e types.Env, object types.Base) (types.Base, error) {
 			case "defmacro!":
 				return evalDefMacro(e, tobject.Forms[1:]...)
 			case "macroexpand":
+				if len(tobject.Forms) < 2 {
+					return nil, nil
+				}
 				return macroExpand(e, tobject.Forms[1])
 			case "fn*":
 				return types.NewFunc(e, Eval, tobject.Forms[1:]...)
@@ -53,7 +127,11 @@ func Eval(e types.Env, object types.Base) (types.Base, error) {
 				list := lst.(*types.List)
 				switch fn := list.Forms[0].(type) {
 				case *types.StdFunc:
-					return fn.Fn(e, list.Forms[1:])
+					val, err := fn.Fn(e, list.Forms[1:])
+					if err == nil {
+						err = chargeSize(e, val)
+					}
+					return val, err
 				case *types.ExtFunc:
 					newEnv, err := fn.Env.Child(fn.Params, list.Forms[1:])
 					if err != nil {
@@ -110,9 +188,12 @@ func evalTry(e types.Env, args ...types.Base) (types.Base, error) {
 	if len(args) > 1 {
 		catch, catchDefined = isPair(args[1])
 		if catchDefined {
+			if len(catch) < 3 {
+				return nil, fmt.Errorf("invalid catch declaration")
+			}
 			catchSym, isCatchSym := catch[0].(types.Symbol)
 			_, isErrSym := catch[1].(types.Symbol)
-			if len(catch) < 3 || !isCatchSym || catchSym != "catch*" || !isErrSym {
+			if !isCatchSym || catchSym != "catch*" || !isErrSym {
 				return nil, fmt.Errorf("invalid catch declaration")
 			}
 		}
@@ -148,20 +229,69 @@ func evalDefMacro(e types.Env, args ...types.Base) (types.Base, error) {
 	return fn, nil
 }
 
+// evalDef binds a value to a name. If a docstring is given between the name and
+// the value it is added to the metadata of the value, as long as the value can
+// hold metadata. A name with :private metadata, like ^:private name, is only
+// visible inside of its namespace.
 func evalDef(e types.Env, args ...types.Base) (types.Base, error) {
 	if len(args) < 2 {
 		return nil, fmt.Errorf("not enough arguments")
 	}
 
-	name, ok := args[0].(types.Symbol)
+	name, private, ok := defName(args[0])
 	if !ok {
 		return nil, fmt.Errorf("non-symbol bind value")
 	}
+	doc, hasDoc := args[1].(string)
+	hasDoc = hasDoc && len(args) > 2
+	if hasDoc {
+		args = args[1:]
+	}
 	value, err := Eval(e, args[1])
-	if err == nil {
-		e.Set(name, value)
+	if err != nil {
+		return value, err
+	}
+	if hasDoc {
+		value = withDoc(value, doc)
 	}
-	return value, err
+	e.Set(name, value)
+	if private {
+		e.SetPrivate(name)
+	}
+	return value, nil
+}
+
+// defName reads the name of a definition, which is either a plain symbol or a
+// symbol with metadata like ^:private name or ^{:private true} name
+func defName(form types.Base) (types.Symbol, bool, bool) {
+	if name, ok := form.(types.Symbol); ok {
+		return name, false, true
+	}
+	lst, ok := form.(*types.List)
+	if !ok || len(lst.Forms) != 3 || lst.Forms[0] != types.Symbol("with-meta") {
+		return "", false, false
+	}
+	name, ok := lst.Forms[1].(types.Symbol)
+	switch meta := lst.Forms[2].(type) {
+	case types.Keyword:
+		return name, meta == "private", ok
+	case *types.Hashmap:
+		return name, meta.Forms[types.Keyword("private")] == true, ok
+	default:
+		return name, false, ok
+	}
+}
+
+func withDoc(value types.Base, doc string) types.Base {
+	meta := []types.Base{}
+	if hm, isMap := types.GetMeta(value).(*types.Hashmap); isMap {
+		meta = hm.ToList()
+	}
+	hm, _ := types.NewHashmap(append(meta, types.Keyword("doc"), doc))
+	if documented, err := types.WithMeta(value, hm); err == nil {
+		return documented
+	}
+	return value
 }
 
 func evalLet(e types.Env, args ...types.Base) (types.Base, types.Env, error) {
@@ -182,7 +312,10 @@ func evalLet(e types.Env, args ...types.Base) (types.Base, types.Env, error) {
 	}
 
 	for i := 0; i < len(definitions); i += 2 {
-		if _, err := evalDef(newEnv, definitions[i:]...); err != nil {
+		if i+1 >= len(definitions) {
+			return nil, nil, fmt.Errorf("odd number of forms in let* bindings")
+		}
+		if _, err := evalDef(newEnv, definitions[i], definitions[i+1]); err != nil {
 			return nil, nil, err
 		}
 	}
@@ -191,6 +324,9 @@ func evalLet(e types.Env, args ...types.Base) (types.Base, types.Env, error) {
 }
 
 func evalDo(e types.Env, args ...types.Base) (types.Base, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
 	_, err := evalAST(e, types.NewList(args[:len(args)-1]...))
 	if err != nil {
 		return nil, err
@@ -234,9 +370,12 @@ func evalQuasiQuote(e types.Env, object types.Base) types.Base {
 	}
 
 	if sym, ok := pair[0].(types.Symbol); ok && sym == "unquote" {
+		if len(pair) < 2 {
+			return nil
+		}
 		return pair[1]
 	} else if nextPair, isp := isPair(pair[0]); isp {
-		if sym, ok := nextPair[0].(types.Symbol); ok && sym == "splice-unquote" {
+		if sym, ok := nextPair[0].(types.Symbol); ok && sym == "splice-unquote" && len(nextPair) > 1 {
 			return types.NewList(types.Symbol("concat"), nextPair[1], evalQuasiQuote(e, types.NewList(pair[1:]...)))
 		}
 	}
@@ -0,0 +1,100 @@
+package types
+
+import "strings"
+
+// Compare orders any two values. It returns a negative number if a comes
+// before b, a positive number if it comes after and 0 if neither does. Values
+// of different types are ordered by type, with nil first, then booleans,
+// numbers, characters, strings, symbols, keywords, lists and vectors, maps,
+// tagged literals and regexes. Other values, like functions, are not ordered.
+func Compare(a, b Base) int {
+	if rankA, rankB := compareRank(a), compareRank(b); rankA != rankB {
+		return rankA - rankB
+	}
+	switch ta := a.(type) {
+	case bool:
+		return compareBools(ta, b.(bool))
+	case float64:
+		return compareNumbers(ta, b.(float64))
+	case Char:
+		return int(ta) - int(b.(Char))
+	case string:
+		return strings.Compare(ta, b.(string))
+	case Symbol:
+		return strings.Compare(string(ta), string(b.(Symbol)))
+	case Keyword:
+		return strings.Compare(string(ta), string(b.(Keyword)))
+	case Collection:
+		return compareForms(ta.Data(), b.(Collection).Data())
+	case *Hashmap:
+		return compareForms(ta.ToList(), b.(*Hashmap).ToList())
+	case *Tagged:
+		tb := b.(*Tagged)
+		if order := strings.Compare(string(ta.Tag), string(tb.Tag)); order != 0 {
+			return order
+		}
+		return Compare(ta.Form, tb.Form)
+	case *Regex:
+		return strings.Compare(ta.String(), b.(*Regex).String())
+	default:
+		return 0
+	}
+}
+
+func compareRank(val Base) int {
+	switch val.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case float64:
+		return 2
+	case Char:
+		return 3
+	case string:
+		return 4
+	case Symbol:
+		return 5
+	case Keyword:
+		return 6
+	case Collection:
+		return 7
+	case *Hashmap:
+		return 8
+	case *Tagged:
+		return 9
+	case *Regex:
+		return 10
+	default:
+		return 11
+	}
+}
+
+func compareBools(a, b bool) int {
+	if a == b {
+		return 0
+	} else if b {
+		return -1
+	}
+	return 1
+}
+
+func compareNumbers(a, b float64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// compareForms orders sequences by their first different item, and then by
+// length
+func compareForms(a, b []Base) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if order := Compare(a[i], b[i]); order != 0 {
+			return order
+		}
+	}
+	return len(a) - len(b)
+}
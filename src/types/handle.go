@@ -0,0 +1,46 @@
+package types
+
+import (
+	"bufio"
+	"io"
+)
+
+// Handle is an open file that is read or written a line at a time. Only one of
+// Reader and Writer is set.
+type Handle struct {
+	Path   string
+	Reader *bufio.Reader
+	Writer *bufio.Writer
+	file   io.Closer
+	closed bool
+}
+
+// NewReadHandle wraps a file that is open for reading
+func NewReadHandle(path string, file io.ReadCloser) *Handle {
+	return &Handle{Path: path, Reader: bufio.NewReader(file), file: file}
+}
+
+// NewWriteHandle wraps a file that is open for writing
+func NewWriteHandle(path string, file io.WriteCloser) *Handle {
+	return &Handle{Path: path, Writer: bufio.NewWriter(file), file: file}
+}
+
+// Closed checks if the handle has been closed
+func (h *Handle) Closed() bool { return h.closed }
+
+// Close flushes anything that has been written and closes the file. Closing a
+// handle more than once does nothing.
+func (h *Handle) Close() error {
+	if h.closed {
+		return nil
+	}
+	h.closed = true
+	var err error
+	if h.Writer != nil {
+		err = h.Writer.Flush()
+	}
+	if closeErr := h.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
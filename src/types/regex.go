@@ -0,0 +1,74 @@
+package types
+
+import (
+	"errors"
+	"regexp"
+)
+
+// Regex is a compiled regular expression, read from #"pattern" literals. It uses
+// the syntax of Go's regexp package.
+type Regex struct{ *regexp.Regexp }
+
+// NewRegex compiles the pattern into a Regex
+func NewRegex(pattern string) (*Regex, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &Regex{Regexp: re}, nil
+}
+
+// Match converts the location of a match in input, as returned by
+// FindStringSubmatchIndex, into a value. It is the matched string when the
+// regex has no groups, otherwise a vector of the match followed by each group,
+// with nil for groups that did not match.
+func (re *Regex) Match(input string, loc []int) Base {
+	if loc == nil {
+		return nil
+	} else if len(loc) == 2 {
+		return input[loc[0]:loc[1]]
+	}
+	groups := make([]Base, len(loc)/2)
+	for i := range groups {
+		if loc[i*2] >= 0 {
+			groups[i] = input[loc[i*2]:loc[i*2+1]]
+		}
+	}
+	return NewVect(groups...)
+}
+
+// Matcher steps through the matches of a Regex in a string, remembering the
+// last match so that its groups can be looked up
+type Matcher struct {
+	Regex   *Regex
+	Input   string
+	matches [][]int
+	last    []int
+}
+
+// NewMatcher creates a matcher for the matches of re in input
+func NewMatcher(re *Regex, input string) *Matcher {
+	return &Matcher{
+		Regex:   re,
+		Input:   input,
+		matches: re.FindAllStringSubmatchIndex(input, -1),
+	}
+}
+
+// Find moves on to the next match and returns it, or nil if there are no more
+func (m *Matcher) Find() Base {
+	if len(m.matches) == 0 {
+		m.last = nil
+		return nil
+	}
+	m.last, m.matches = m.matches[0], m.matches[1:]
+	return m.Regex.Match(m.Input, m.last)
+}
+
+// Groups returns the last match that Find returned
+func (m *Matcher) Groups() (Base, error) {
+	if m.last == nil {
+		return nil, errors.New("no match found")
+	}
+	return m.Regex.Match(m.Input, m.last), nil
+}
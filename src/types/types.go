@@ -3,6 +3,9 @@ package types
 import (
 	"errors"
 	"fmt"
+	"reflect"
+	"sort"
+	"sync"
 )
 
 type (
@@ -14,14 +17,35 @@ type (
 	Symbol string
 	// Keyword is like a ruby symbol. It is a simplified string
 	Keyword string
+	// Char is a single unicode character, written like \a, \newline or \u00e9
+	Char rune
+	// Tagged is a value read from an EDN tagged literal, like
+	// #inst "2024-10-18T00:00:00Z", that has no type of its own
+	Tagged struct {
+		Tag  Symbol
+		Form Base
+	}
 )
 
+// CharNames are the names of the characters that cannot be written literally
+// after a backslash
+var CharNames = map[string]Char{
+	"newline":   '\n',
+	"space":     ' ',
+	"tab":       '\t',
+	"return":    '\r',
+	"backspace": '\b',
+	"formfeed":  '\f',
+}
+
 // Env is the object that is passed around containing the definition of the running environment
 type Env interface {
 	Child([]Base, []Base) (Env, error)
 	Find(Symbol) Env
 	Set(Symbol, Base)
+	SetPrivate(Symbol)
 	Get(Symbol) (Base, error)
+	Symbols() []Symbol
 }
 
 // Collection is a general interface used to abstract the differences between Lists and Vectors
@@ -57,10 +81,22 @@ func NewVect(forms ...Base) *Vector {
 // Data satisfies the Collection interface, making it easier to handle in common situations
 func (l *Vector) Data() []Base { return l.Forms }
 
-// Hashmap is a data structure that maps key to values
+// Hashmap is a data structure that maps key to values. A sorted map keeps its
+// keys in the order of its comparator, other maps list their keys in the order
+// of Compare so that they always print the same way.
 type Hashmap struct {
 	Forms map[Base]Base
 	Meta  Base
+	// Comparator is the function that a sorted map is ordered by, nil for
+	// other maps
+	Comparator Base
+	// SortedKeys are the keys of a sorted map in order
+	SortedKeys []Base
+	// order caches the keys of other maps in the order of Compare. Maps are
+	// not changed once they are made so it is only sorted the first time that
+	// it is needed.
+	order     []Base
+	orderOnce sync.Once
 }
 
 // NewHashmap will create a new hashmap using an array of keys and values that are interleaved.
@@ -88,36 +124,70 @@ func NewHashmap(values []Base, excludeKeys ...Base) (*Hashmap, error) {
 
 // ToList will interleave keys and values back into an array
 func (hm *Hashmap) ToList() []Base {
-	values := []Base{}
-	for key, val := range hm.Forms {
-		values = append(values, key)
-		values = append(values, val)
+	keys := hm.orderedKeys()
+	values := make([]Base, 0, len(keys)*2)
+	for _, key := range keys {
+		values = append(values, key, hm.Forms[key])
 	}
 	return values
 }
 
-// Keys will return an array of all the keys in the map
+// Keys will return an array of all the keys in the map, in order
 func (hm *Hashmap) Keys() []Base {
-	keys := make([]Base, 0, len(hm.Forms))
-	for k := range hm.Forms {
-		keys = append(keys, k)
-	}
-	return keys
+	return append([]Base{}, hm.orderedKeys()...)
 }
 
-// Vals will return an array of all the values in the map
+// Vals will return an array of all the values in the map, in the order of
+// their keys
 func (hm *Hashmap) Vals() []Base {
-	vals := make([]Base, 0, len(hm.Forms))
-	for _, v := range hm.Forms {
-		vals = append(vals, v)
+	keys := hm.orderedKeys()
+	vals := make([]Base, len(keys))
+	for i, k := range keys {
+		vals[i] = hm.Forms[k]
 	}
 	return vals
 }
 
+// orderedKeys returns the keys in order without copying them, so they must
+// not be changed
+func (hm *Hashmap) orderedKeys() []Base {
+	if hm.Comparator != nil {
+		return hm.SortedKeys
+	}
+	hm.orderOnce.Do(func() {
+		keys := make([]Base, 0, len(hm.Forms))
+		for k := range hm.Forms {
+			keys = append(keys, k)
+		}
+		sort.SliceStable(keys, func(i, j int) bool {
+			if order := Compare(keys[i], keys[j]); order != 0 {
+				return order < 0
+			} else if order := Compare(hm.Forms[keys[i]], hm.Forms[keys[j]]); order != 0 {
+				return order < 0
+			}
+			return identity(keys[i]) < identity(keys[j])
+		})
+		hm.order = keys
+	})
+	return hm.order
+}
+
+// identity orders values that Compare cannot, like functions and atoms, by
+// their address so that they come out in the same order each time
+func identity(val Base) uintptr {
+	if v := reflect.ValueOf(val); v.Kind() == reflect.Ptr {
+		return v.Pointer()
+	}
+	return 0
+}
+
 // StdFunc wraps a standard library function that does not need closure support
 type StdFunc struct {
 	Fn   func(Env, []Base) (Base, error)
 	Meta Base
+	// Doc is the :arglists and :doc of a builtin function. It is kept apart
+	// from Meta because builtin functions have no metadata.
+	Doc *Hashmap
 }
 
 // Func is a helper to convert a simple function into a StdFunc
@@ -175,6 +245,7 @@ func (fn *ExtFunc) Clone() *ExtFunc {
 		Env:     fn.Env,
 		eval:    fn.eval,
 		IsMacro: fn.IsMacro,
+		Meta:    fn.Meta,
 	}
 }
 
@@ -191,6 +262,55 @@ func CallFunc(e Env, baseFn Base, arguments []Base) (Base, error) {
 	}
 }
 
+// GetMeta will return the metadata of any value that can hold it
+func GetMeta(val Base) Base {
+	switch tval := val.(type) {
+	case *List:
+		return tval.Meta
+	case *Vector:
+		return tval.Meta
+	case *Hashmap:
+		return tval.Meta
+	case *StdFunc:
+		return tval.Meta
+	case *ExtFunc:
+		return tval.Meta
+	default:
+		return nil
+	}
+}
+
+// WithMeta will return a copy of the value with the metadata attached so that
+// the original value is left unmutated
+func WithMeta(val, meta Base) (Base, error) {
+	switch tval := val.(type) {
+	case *List:
+		list := NewList(tval.Forms...)
+		list.Meta = meta
+		return list, nil
+	case *Vector:
+		vect := NewVect(tval.Forms...)
+		vect.Meta = meta
+		return vect, nil
+	case *Hashmap:
+		hmap, _ := NewHashmap(tval.ToList())
+		hmap.Meta = meta
+		hmap.Comparator = tval.Comparator
+		hmap.SortedKeys = tval.SortedKeys
+		return hmap, nil
+	case *StdFunc:
+		clonedFn := Func(tval.Fn)
+		clonedFn.Meta, clonedFn.Doc = meta, tval.Doc
+		return clonedFn, nil
+	case *ExtFunc:
+		clonedFn := tval.Clone()
+		clonedFn.Meta = meta
+		return clonedFn, nil
+	default:
+		return nil, errors.New("invalid data type for metadata")
+	}
+}
+
 // UserError wraps values that are thrown by a user. This really can be any value
 // but this is used in an error state
 type UserError struct {